@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// actionSpec describes an action to the code around the big switch in
+// runAction: which flags it can't run without and how it may be treated.
+type actionSpec struct {
+	// idName is what --id names for this action, if the action takes one
+	idName string
+	// needsID, if set, decides from the other options whether --id is
+	// actually required
+	needsID func(options Options) bool
+	// flags are the other flags the action requires, by long name
+	flags []string
+	// lightpad actions talk only to Lightpads, using the HAT they're given,
+	// and never need to log in to the Plum web API
+	lightpad bool
+	// offline actions only look at what's been cached locally, and need
+	// neither the web API nor a Lightpad
+	offline bool
+	// read actions only fetch state and are therefore safe to repeat with
+	// --watch
+	read bool
+	// dryRun actions can print the requests they would send to the pad,
+	// with --dry-run, instead of sending them
+	dryRun bool
+	// untilStopped actions keep running until they're interrupted, which is
+	// how they're meant to end rather than a failure
+	untilStopped bool
+	// idempotent actions set state to a given value rather than changing it
+	// relative to what's there, so are safe to retry. Retries are limited to
+	// these and read actions unless --retry-non-idempotent is given.
+	idempotent bool
+}
+
+// padFlags are required by every Lightpad action outside of --test.
+var padFlags = []string{"lpip", "port", "hat"}
+
+var actions = map[string]actionSpec{
+	"GetHouses":          {read: true},
+	"GetHouse":           {idName: "House ID", read: true},
+	"GetLocation":        {idName: "House ID", read: true},
+	"GetHouseTree":       {idName: "House ID", needsID: optionalID, read: true},
+	"GetScenes":          {idName: "House ID", read: true},
+	"GetScene":           {idName: "Scene ID", read: true},
+	"GetRoom":            {idName: "Room ID", read: true},
+	"GetLoad":            {idName: "Logical Load ID", read: true},
+	"GetLightpad":        {idName: "Lightpad ID", read: true},
+	"GetGestures":        {idName: "Lightpad ID", read: true},
+	"GetLightpadConfig":  {idName: "Lightpad ID", read: true},
+	"DiffLightpadConfig": {idName: "Lightpad ID", flags: []string{"conf"}, read: true},
+	"IsProvisioned":      {idName: "Lightpad ID", read: true},
+	"Serve":              {idempotent: true, untilStopped: true},
+	"CacheStatus":        {offline: true, read: true},
+
+	"GetLoadMetrics":    {flags: padFlags, lightpad: true, read: true},
+	"SetLevel":          {flags: append([]string{"conf"}, padFlags...), lightpad: true, dryRun: true, idempotent: true},
+	"SetLightpadConfig": {flags: append([]string{"conf"}, padFlags...), lightpad: true, dryRun: true, idempotent: true},
+	"SetLoadConfig":     {flags: append([]string{"conf"}, padFlags...), lightpad: true, dryRun: true, idempotent: true},
+	"SetLoadGlow":       {idName: "Logical Load ID", flags: padFlags, lightpad: true, dryRun: true, idempotent: true},
+	"Subscribe":         {idName: "Logical Load ID", needsID: subscribeNeedsID, flags: padFlags, lightpad: true, idempotent: true, untilStopped: true},
+	"IdentifyLightpad":  {idName: "Logical Load ID", flags: padFlags, lightpad: true, idempotent: true},
+	"GlowFor":           {idName: "Logical Load ID", flags: padFlags, lightpad: true, idempotent: true, untilStopped: true},
+	"MirrorLevel":       {flags: []string{"source", "target"}, lightpad: true, untilStopped: true},
+	"Bridge":            {idName: "Logical Load ID", flags: append([]string{"mqtt"}, padFlags...), lightpad: true, untilStopped: true},
+	"Exporter":          {idName: "Logical Load ID", flags: padFlags, lightpad: true, idempotent: true, untilStopped: true},
+
+	"Discover": {lightpad: true, read: true},
+}
+
+// needsFlag reports whether the action requires the flag with the given long
+// name.
+func (spec actionSpec) needsFlag(name string) bool {
+	for _, flag := range spec.flags {
+		if flag == name {
+			return true
+		}
+	}
+	return false
+}
+
+// optionalID is the needsID of actions that work on everything when they're
+// given no ID.
+func optionalID(options Options) bool {
+	return false
+}
+
+// subscribeNeedsID reports whether Subscribe has been asked to do anything
+// that involves talking to the load as well as listening to the pad.
+func subscribeNeedsID(options Options) bool {
+	return options.Keepalive > 0 || options.PowerGlow || options.EmitInitialState
+}
+
+// runsUntilStopped reports whether the run only ends when it's interrupted:
+// an untilStopped action, or any action under --watch or --conf-watch.
+func runsUntilStopped(options Options) bool {
+	return actions[options.Action].untilStopped || options.Watch || options.ConfWatch != ""
+}
+
+func isIdempotent(action string) bool {
+	spec := actions[action]
+	return spec.read || spec.idempotent
+}
+
+func isPadFlag(name string) bool {
+	for _, padFlag := range padFlags {
+		if name == padFlag {
+			return true
+		}
+	}
+	return false
+}
+
+// flagDescriptions name the flags an action may require, for error messages.
+var flagDescriptions = map[string]string{
+	"lpip":   "Lightpad IP address",
+	"port":   "Lightpad port",
+	"hat":    "House Access Token",
+	"conf":   "JSON configuration",
+	"source": "MirrorLevel source pad",
+	"target": "MirrorLevel target pad",
+	"mqtt":   "MQTT broker URL",
+}
+
+// flagGiven reports whether the flag with the given long name has a value.
+func flagGiven(name string, options Options) bool {
+	switch name {
+	case "lpip":
+		// a --lightpad-id (or a load's --name) can stand in for the
+		// address, as can discovering every pad with --all
+		return options.LightpadIP != "" || options.LightpadID != "" || options.Name != "" || options.All
+	case "port":
+		return options.Port != 0
+	case "hat":
+		// with credentials the HAT can be fetched from the web API
+		return options.HAT != "" || options.HATs != "" || (options.Email != "" && !options.NoAuth)
+	case "conf":
+		return options.Conf != "" || options.ConfWatch != ""
+	case "source":
+		return options.Source != ""
+	case "target":
+		return options.Target != ""
+	case "mqtt":
+		return options.MQTT != ""
+	}
+	return false
+}
+
+// checkActionFlags makes sure options has everything the action needs before
+// anything touches the network, and exits listing what's missing if not.
+func checkActionFlags(options Options) {
+	spec, ok := actions[options.Action]
+	if !ok {
+		fmt.Printf("Action '%s' not recognized\n", options.Action)
+		exit(1)
+	}
+	if options.DryRun && !spec.dryRun {
+		fmt.Printf("--dry-run only works with SetLevel, SetLightpadConfig, SetLoadConfig and SetLoadGlow, not '%s'\n", options.Action)
+		exit(1)
+	}
+	var missing []string
+	needsID := spec.idName != "" && (spec.needsID == nil || spec.needsID(options))
+	if needsID && options.ID == "" && options.Name == "" {
+		missing = append(missing, fmt.Sprintf("--id (%s)", spec.idName))
+	}
+	for _, name := range spec.flags {
+		if options.TestMode && isPadFlag(name) {
+			// the test Lightpad doesn't need an address or HAT
+			continue
+		}
+		if !flagGiven(name, options) {
+			missing = append(missing, fmt.Sprintf("--%s (%s)", name, flagDescriptions[name]))
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("%s requires %s\n", options.Action, strings.Join(missing, ", "))
+		if prettyErrors && spec.lightpad && options.HAT == "" {
+			fmt.Println("Hint: run GetHouse to obtain a HAT")
+		}
+		exit(1)
+	}
+	if needsID && options.ID != "" && prettyErrors {
+		// Subscribe takes one ID per pad
+		for _, id := range strings.Split(options.ID, ",") {
+			if !uuidRE.MatchString(strings.TrimSpace(id)) {
+				fmt.Fprintf(os.Stderr, "Hint: %s %q doesn't look like a UUID\n", spec.idName, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/maplebed/libplumraw"
+)
+
+// bridgeTopics maps the event types heard from a Lightpad to the topic their
+// values are published on, under <prefix>/<llid>/.
+var bridgeTopics = map[string]string{
+	"dimmerchange": "level",
+	"power":        "power",
+	"pirSignal":    "motion",
+}
+
+// runBridge publishes the events from the Lightpad to the MQTT broker in
+// --mqtt and sets the load's level from <prefix>/<llid>/set_level (or turns it
+// on and off from set_state), until the pad closes the connection for good.
+// With --ha-discovery it also announces the load to Home Assistant.
+func runBridge(options Options) {
+	stateChanges := make(chan libplumraw.Event, 0)
+	lp := newLightpad(options, stateChanges)
+	err := lp.Subscribe(context.Background())
+	checkPadError(err)
+	// the subscription's pad is replaced if it reconnects, so send commands
+	// through a pad of our own
+	ctl := newLightpad(options, nil)
+
+	topic := func(name string) string {
+		return strings.Join([]string{options.MQTTPrefix, options.ID, name}, "/")
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(options.MQTT).
+		SetClientID("plumcliraw-"+options.ID).
+		SetAutoReconnect(true).
+		SetWill(topic("available"), "offline", 1, true)
+	if options.MQTTUser != "" {
+		opts.SetUsername(options.MQTTUser)
+		opts.SetPassword(options.MQTTPassword)
+	}
+	var discovery map[string][]byte
+	if options.HADiscovery {
+		discovery, err = haDiscoveryConfigs(options, topic)
+		checkError(err)
+	}
+	setLevel := func(msg mqtt.Message, level int) {
+		if err := ctl.SetLogicalLoadLevel(level); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to set level from %s: %s\n", msg.Topic(), err)
+		}
+	}
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		// the broker forgets our subscriptions when we reconnect, so make
+		// them every time we connect
+		for t, config := range discovery {
+			c.Publish(t, 1, true, config)
+		}
+		c.Publish(topic("available"), 1, true, "online")
+		c.Subscribe(topic("set_level"), 1, func(_ mqtt.Client, msg mqtt.Message) {
+			level, err := strconv.Atoi(strings.TrimSpace(string(msg.Payload())))
+			if err != nil || level < 0 || level > 255 {
+				fmt.Fprintf(os.Stderr, "Warning: ignoring %s of %q; it must be a level from 0 to 255\n", msg.Topic(), msg.Payload())
+				return
+			}
+			setLevel(msg, level)
+		})
+		c.Subscribe(topic("set_state"), 1, func(_ mqtt.Client, msg mqtt.Message) {
+			switch strings.TrimSpace(string(msg.Payload())) {
+			case "ON":
+				setLevel(msg, 255)
+			case "OFF":
+				setLevel(msg, 0)
+			default:
+				fmt.Fprintf(os.Stderr, "Warning: ignoring %s of %q; it must be ON or OFF\n", msg.Topic(), msg.Payload())
+			}
+		})
+	})
+	client := mqtt.NewClient(opts)
+	tok := client.Connect()
+	tok.Wait()
+	checkError(tok.Error())
+	fmt.Printf("bridging %s to %s\n", options.LightpadIP, options.MQTT)
+
+	events := make(chan padEvent)
+	go func() {
+		listen(options, nil, stateChanges, events)
+		close(events)
+	}()
+	for pe := range events {
+		if unknown, ok := pe.ev.(libplumraw.LPEUnknown); ok {
+			client.Publish(topic("unknown"), 0, false, unknown.Message)
+			continue
+		}
+		eventType, value := eventValue(pe.ev)
+		name, ok := bridgeTopics[eventType]
+		if !ok {
+			continue
+		}
+		// motion is a moment rather than a state, so isn't retained
+		client.Publish(topic(name), 0, name != "motion", strconv.Itoa(value))
+		if name == "level" {
+			state := "ON"
+			if value == 0 {
+				state = "OFF"
+			}
+			client.Publish(topic("state"), 0, true, state)
+		}
+	}
+	client.Publish(topic("available"), 1, true, "offline").Wait()
+	client.Disconnect(250)
+}
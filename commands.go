@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	flag "github.com/jessevdk/go-flags"
+)
+
+// commandHelp is the one line description shown for each action's subcommand.
+var commandHelp = map[string]string{
+	"GetHouses":          "Get a list of all House IDs",
+	"GetHouse":           "Get the description of a House",
+	"GetLocation":        "Get the location and time zone of a House",
+	"GetHouseTree":       "Get a House (or all Houses) with its Rooms, Loads and Lightpads as one JSON document",
+	"GetScenes":          "Get a list of all Scene IDs",
+	"GetScene":           "Get the description of a Scene",
+	"GetRoom":            "Get the description of a Room",
+	"GetLoad":            "Get the description of a Load",
+	"GetLightpad":        "Get the description of a Lightpad",
+	"GetGestures":        "Get the number of custom gestures on a Lightpad",
+	"GetLightpadConfig":  "Get the current config of a Lightpad",
+	"DiffLightpadConfig": "Show which fields of a Lightpad's config --conf would change",
+	"IsProvisioned":      "Print whether a Lightpad is provisioned; exits 1 if not, except with --watch",
+	"Serve":              "Serve a REST API for controlling every load",
+
+	"GetLoadMetrics":    "Get metrics about current power draw",
+	"SetLevel":          "Set the dim level range 0 (off) to 255 (on)",
+	"SetLightpadConfig": "Upload a new Lightpad config to the pad",
+	"SetLoadConfig":     "Upload a new Load config to the pad",
+	"SetLoadGlow":       "Turn on the glow ring manually",
+	"Subscribe":         "Listen for state changes from the Lightpad",
+	"IdentifyLightpad":  "Flash the glow ring so you can find the pad",
+	"GlowFor":           "Light the glow ring, then clear it after --duration or on Ctrl-C",
+	"MirrorLevel":       "Apply every level change on the --source load to the --target",
+	"Bridge":            "Bridge the pad's events and level to an MQTT broker",
+	"Exporter":          "Serve Prometheus metrics of the load's level, power and events",
+	"Discover":          "Listen for Lightpad heartbeats and print each pad's IP, port and Lightpad ID",
+}
+
+// globalFlags mean the same to every action.
+var globalFlags = []string{
+	"config", "email", "password", "password-stdin", "action", "list_actions", "version",
+	"retries", "retry-on-status", "backoff-base", "backoff-cap", "retry-non-idempotent", "retry-budget",
+	"request-id", "stats", "slow-threshold", "output", "format-level", "timezone",
+	"pretty-errors", "verbose", "summary-file", "no-auth", "test", "strip-null-fields",
+	"json-array", "jq",
+}
+
+// actionsWhere lists the actions whose spec satisfies match.
+func actionsWhere(match func(spec actionSpec) bool) []string {
+	var names []string
+	for name, spec := range actions {
+		if match(spec) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	// idActions take --id or --name: those that name an entity, and the
+	// Lightpad actions, whose --id is the load to act on
+	idActions = actionsWhere(func(spec actionSpec) bool { return spec.idName != "" || spec.needsFlag("lpip") })
+	// padActions talk to a Lightpad of their own
+	padActions = actionsWhere(func(spec actionSpec) bool { return spec.lightpad })
+	// hatActions need a HAT for the Lightpad
+	hatActions = actionsWhere(func(spec actionSpec) bool { return spec.needsFlag("hat") })
+	// addressActions find a Lightpad's address from its ID if there's no --lpip
+	addressActions = actionsWhere(func(spec actionSpec) bool { return spec.needsFlag("lpip") })
+	// readActions can be repeated with --watch
+	readActions = actionsWhere(func(spec actionSpec) bool { return spec.read })
+	// dryRunActions can print their requests instead of sending them
+	dryRunActions = actionsWhere(func(spec actionSpec) bool { return spec.dryRun })
+)
+
+// commandFlags are the flags that mean something only to certain actions,
+// by long name, besides the ones each action requires in its actionSpec.
+// Given to the subcommand for any other action, they're rejected as the
+// command line is parsed, rather than silently ignored. Every flag is either
+// here, in flagDescriptions or in globalFlags.
+var commandFlags = map[string][]string{
+	"id":                       idActions,
+	"name":                     idActions,
+	"house-id":                 idActions,
+	"room-id":                  idActions,
+	"load-id":                  idActions,
+	"lightpad-id":              idActions,
+	"scene-id":                 idActions,
+	"validate-id":              idActions,
+	"hat-house":                hatActions,
+	"hats":                     hatActions,
+	"discover-for":             append([]string{"Discover"}, addressActions...),
+	"discovery-ttl":            addressActions,
+	"refresh-discovery":        addressActions,
+	"pad-tls-min-version":      append([]string{"Serve"}, padActions...),
+	"exit-code-on-unreachable": padActions,
+	"watch":                    readActions,
+	"interval":                 append([]string{"Exporter"}, readActions...),
+	"min-interval":             append([]string{"Exporter", "Serve"}, readActions...),
+	"allow-fast-polling":       append([]string{"Exporter", "Serve"}, readActions...),
+	"dry-run":                  dryRunActions,
+	"cache-ttl":                append([]string{"GetHouseTree", "Serve", "CacheStatus"}, idActions...),
+	"refresh":                  append([]string{"GetHouseTree", "Serve"}, idActions...),
+	"allow-empty-results":      {"GetHouses", "GetScenes", "Discover"},
+	"output-null-on-error":     readActions,
+	"conf":                     {"SetLoadGlow", "IdentifyLightpad", "GlowFor"},
+	"color":                    {"SetLoadGlow", "IdentifyLightpad", "GlowFor"},
+	"intensity":                {"SetLoadGlow", "IdentifyLightpad", "GlowFor"},
+	"timeout":                  {"SetLoadGlow", "IdentifyLightpad", "GlowFor"},
+	"duration":                 {"GlowFor"},
+	"blink-count":              {"IdentifyLightpad"},
+	"blink-interval":           {"IdentifyLightpad"},
+	"value-only":               {"GetLoadMetrics"},
+	"value-field":              {"GetLoadMetrics"},
+	"level-step":               {"SetLevel", "Serve"},
+	"invert":                   {"MirrorLevel"},
+	"debounce":                 {"MirrorLevel"},
+	"mqtt-prefix":              {"Bridge"},
+	"mqtt-user":                {"Bridge"},
+	"mqtt-password":            {"Bridge"},
+	"ha-discovery":             {"Bridge"},
+	"ha-prefix":                {"Bridge"},
+	"ha-name":                  {"Bridge"},
+	"tree-file":                {"GetHouseTree"},
+	"resolve":                  {"GetScenes", "GetHouseTree"},
+	"include-scenes":           {"GetHouseTree"},
+	"conf-watch":               {"SetLightpadConfig", "SetLoadConfig"},
+	"apply-on-start":           {"SetLightpadConfig", "SetLoadConfig"},
+	"all":                      {"Subscribe"},
+	"since":                    {"Subscribe"},
+	"keepalive":                {"Subscribe"},
+	"concurrent-pad-reads":     {"Subscribe"},
+	"max-events-per-second":    {"Subscribe"},
+	"events-only":              {"Subscribe"},
+	"telemetry-only":           {"Subscribe"},
+	"fail-on-unknown-event":    {"Subscribe"},
+	"sqlite":                   {"Subscribe"},
+	"emit-initial-state":       {"Subscribe"},
+	"power-glow":               {"Subscribe"},
+	"watts-max":                {"Subscribe"},
+	"max-reconnects":           {"Subscribe", "Bridge", "Exporter", "Serve"},
+	"sink":                     {"Subscribe", "GetLoadMetrics"},
+	"listen":                   {"Exporter", "Serve"},
+
+	"max-concurrent-subscribes": {"Subscribe"},
+}
+
+// takesFlag reports whether action has any use for the flag with the given
+// long name.
+func takesFlag(action, name string) bool {
+	_, required := flagDescriptions[name]
+	scoped, ok := commandFlags[name]
+	if !required && !ok {
+		// a global flag, such as --output or --retries
+		return true
+	}
+	if actions[action].needsFlag(name) {
+		return true
+	}
+	for _, a := range scoped {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// actionFlags lists the flags the action takes besides the global ones.
+func actionFlags(action string) []string {
+	var names []string
+	for _, name := range scopedFlags() {
+		if takesFlag(action, name) {
+			names = append(names, "--"+name)
+		}
+	}
+	return names
+}
+
+// actionCommand is the subcommand for one action. Running it selects the
+// action and rejects any flag given on the command line that the action has
+// no use for; the rest are the global ones, which go-flags accepts after the
+// subcommand as well as before it.
+type actionCommand struct {
+	action  string
+	options *Options
+	parser  *flag.Parser
+}
+
+func (c *actionCommand) Execute(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("%s takes no arguments, got %s", commandName(c.action), strings.Join(args, " "))
+	}
+	if c.options.Action != "" && c.options.Action != c.action {
+		return fmt.Errorf("--action %s conflicts with the %s command", c.options.Action, commandName(c.action))
+	}
+	var foreign []string
+	for _, name := range scopedFlags() {
+		opt := c.parser.FindOptionByLongName(name)
+		if opt != nil && opt.IsSet() && !opt.IsSetDefault() && !takesFlag(c.action, name) {
+			foreign = append(foreign, "--"+name)
+		}
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("%s doesn't take %s", commandName(c.action), strings.Join(foreign, ", "))
+	}
+	c.options.Action = c.action
+	return nil
+}
+
+// scopedFlags lists, in order, every flag that only some actions take.
+func scopedFlags() []string {
+	var names []string
+	for name := range flagDescriptions {
+		names = append(names, name)
+	}
+	for name := range commandFlags {
+		if _, ok := flagDescriptions[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandName turns an action name like GetLoadMetrics into its subcommand
+// name, get-load-metrics.
+func commandName(action string) string {
+	var name []rune
+	for i, r := range action {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				name = append(name, '-')
+			}
+			r = unicode.ToLower(r)
+		}
+		name = append(name, r)
+	}
+	return string(name)
+}
+
+// addCommands registers every action as a subcommand of web or pad, so
+// `plumcliraw web get-house --id X` is the same as `-a GetHouse --id X`.
+// Subcommands are optional so that --action keeps working, but only a
+// subcommand checks that the flags given belong to its action.
+func addCommands(parser *flag.Parser, options *Options) error {
+	parser.SubcommandsOptional = true
+	web, err := parser.AddCommand("web", "Plum web API actions", "Actions that call the Plum web API; they need --email and --password.", &struct{}{})
+	if err != nil {
+		return err
+	}
+	pad, err := parser.AddCommand("pad", "Lightpad actions", "Actions that talk directly to a Lightpad; most need --lpip, --port and --hat.", &struct{}{})
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		group := web
+		if actions[name].lightpad {
+			group = pad
+		}
+		help, ok := commandHelp[name]
+		if !ok {
+			help = name
+		}
+		long := help
+		if flags := actionFlags(name); len(flags) > 0 {
+			long += "\n\nBesides the global flags, it takes " + strings.Join(flags, ", ") + "."
+		}
+		_, err := group.AddCommand(commandName(name), help, long, &actionCommand{action: name, options: options, parser: parser})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestEveryFlagScoped makes sure a new flag isn't accepted by every
+// subcommand just because nobody said which actions it belongs to.
+func TestEveryFlagScoped(t *testing.T) {
+	global := make(map[string]bool)
+	for _, name := range globalFlags {
+		global[name] = true
+	}
+	fields := reflect.TypeOf(Options{})
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Field(i).Tag.Get("long")
+		_, required := flagDescriptions[name]
+		_, scoped := commandFlags[name]
+		if !global[name] && !required && !scoped {
+			t.Errorf("--%s is in neither globalFlags, flagDescriptions nor commandFlags", name)
+		}
+		if global[name] && (required || scoped) {
+			t.Errorf("--%s is global but also scoped to some actions", name)
+		}
+	}
+	for name, scopedTo := range commandFlags {
+		for _, action := range scopedTo {
+			if _, ok := actions[action]; !ok {
+				t.Errorf("--%s is scoped to unknown action %s", name, action)
+			}
+		}
+	}
+}
+
+func TestTakesFlag(t *testing.T) {
+	takes := []struct {
+		action, flag string
+		want         bool
+	}{
+		{"SetLevel", "lpip", true},
+		{"SetLevel", "level-step", true},
+		{"SetLevel", "id", true},
+		{"SetLevel", "dry-run", true},
+		{"GetHouse", "lpip", false},
+		{"GetHouse", "level-step", false},
+		{"GetHouse", "watch", true},
+		{"GetHouse", "output", true},
+		{"Subscribe", "conf", false},
+		{"Subscribe", "sqlite", true},
+		{"Subscribe", "discover-for", true},
+		{"GetLoadMetrics", "value-only", true},
+		{"Exporter", "interval", true},
+		{"Exporter", "watch", false},
+		{"MirrorLevel", "debounce", true},
+		{"GetHouses", "id", false},
+	}
+	for _, tt := range takes {
+		if got := takesFlag(tt.action, tt.flag); got != tt.want {
+			t.Errorf("takesFlag(%s, --%s) = %t, want %t", tt.action, tt.flag, got, tt.want)
+		}
+	}
+}
+
+func TestCommandName(t *testing.T) {
+	for action, want := range map[string]string{
+		"GetLoadMetrics": "get-load-metrics",
+		"IsProvisioned":  "is-provisioned",
+		"Discover":       "discover",
+	} {
+		if got := commandName(action); got != want {
+			t.Errorf("commandName(%s) = %s, want %s", action, got, want)
+		}
+	}
+}
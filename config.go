@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	flag "github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v2"
+)
+
+// configFile holds the defaults that can be kept in ~/.plumcliraw.yaml (or
+// .toml) instead of being passed on every invocation.
+type configFile struct {
+	Email      string `yaml:"email" toml:"email"`
+	Password   string `yaml:"password" toml:"password"`
+	HAT        string `yaml:"hat" toml:"hat"`
+	LightpadIP string `yaml:"lpip" toml:"lpip"`
+	Port       int    `yaml:"port" toml:"port"`
+	Output     string `yaml:"output" toml:"output"`
+}
+
+// defaultConfigPaths are tried in order when --config isn't given.
+var defaultConfigPaths = []string{".plumcliraw.yaml", ".plumcliraw.yml", ".plumcliraw.toml"}
+
+// findConfig returns the config file to read: --config if it was given,
+// otherwise the first of the default paths in the home directory that
+// exists, or "" if there is none.
+func findConfig(path string) string {
+	if path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	for _, name := range defaultConfigPaths {
+		p := filepath.Join(home, name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// readConfig parses the config file at path as TOML if it ends in .toml and
+// as YAML otherwise.
+func readConfig(path string) (configFile, error) {
+	var conf configFile
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, err
+	}
+	if strings.HasSuffix(path, ".toml") {
+		_, err = toml.Decode(string(buf), &conf)
+	} else {
+		err = yaml.Unmarshal(buf, &conf)
+	}
+	if err != nil {
+		return conf, fmt.Errorf("failed to parse config file %s: %s", path, err)
+	}
+	if conf.Password != "" {
+		if fi, err := os.Stat(path); err == nil && fi.Mode().Perm()&0077 != 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %s holds a password but can be read by other users; chmod 600 it\n", path)
+		}
+	}
+	return conf, nil
+}
+
+// applyConfig fills in options from the config file, leaving alone any option
+// that was given on the command line or in its environment variable.
+func applyConfig(parser *flag.Parser, options *Options) error {
+	path := findConfig(options.Config)
+	if path == "" {
+		return nil
+	}
+	conf, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	unset := func(name string) bool {
+		opt := parser.FindOptionByLongName(name)
+		if opt == nil || opt.IsSet() {
+			return false
+		}
+		_, inEnv := os.LookupEnv(opt.EnvDefaultKey)
+		return opt.EnvDefaultKey == "" || !inEnv
+	}
+	if conf.Email != "" && unset("email") {
+		options.Email = conf.Email
+	}
+	if conf.Password != "" && unset("password") {
+		options.Password = conf.Password
+	}
+	if conf.HAT != "" && unset("hat") {
+		options.HAT = conf.HAT
+	}
+	if conf.LightpadIP != "" && unset("lpip") {
+		options.LightpadIP = conf.LightpadIP
+	}
+	if conf.Port != 0 && unset("port") {
+		options.Port = conf.Port
+	}
+	if conf.Output != "" && unset("output") {
+		options.Output = conf.Output
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	confWatchPoll     = 250 * time.Millisecond
+	confWatchDebounce = 500 * time.Millisecond
+)
+
+// watchConf calls apply with the contents of path every time the file
+// changes, and never returns. Editors often write a file several times while
+// saving, so a change is only applied once the file has been left alone for
+// confWatchDebounce. Errors are reported but don't stop the watch, so a typo
+// can simply be fixed and saved again.
+func watchConf(path string, applyOnStart bool, apply func(conf string) error) {
+	var applied, changed time.Time
+	if !applyOnStart {
+		if info, err := os.Stat(path); err == nil {
+			applied = info.ModTime()
+		}
+	}
+	fmt.Printf("watching %s for changes\n", path)
+	for range time.Tick(confWatchPoll) {
+		info, err := os.Stat(path)
+		if err != nil {
+			// the file may be briefly missing while an editor replaces it
+			continue
+		}
+		if info.ModTime().Equal(applied) {
+			continue
+		}
+		if !info.ModTime().Equal(changed) {
+			changed = info.ModTime()
+			continue
+		}
+		if time.Since(changed) < confWatchDebounce {
+			continue
+		}
+		applied = changed
+		buf, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = apply(string(buf))
+		}
+		if err != nil {
+			fmt.Printf("Error: failed to apply %s: %s\n", path, err)
+			continue
+		}
+		fmt.Printf("applied %s\n", path)
+	}
+}
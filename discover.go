@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// heartbeatPort is the UDP port Lightpads broadcast their heartbeats to.
+const heartbeatPort = 43770
+
+// listenHeartbeats returns the Lightpad announcements heard on the LAN until
+// ctx is done. Under --test it announces a single canned pad instead.
+func listenHeartbeats(ctx context.Context, options Options) chan libplumraw.LightpadAnnouncement {
+	if !options.TestMode {
+		// libplumraw doesn't say when it can't bind the port, so try it
+		// first and fail with something the user can act on.
+		probe, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", heartbeatPort))
+		if err != nil {
+			checkError(heartbeatBindError(err))
+		}
+		probe.Close()
+		hb := &libplumraw.DefaultLightpadHeartbeat{}
+		anns := hb.Listen(ctx)
+		if anns == nil {
+			checkError(fmt.Errorf("couldn't listen for Lightpad heartbeats on UDP port %d; give the pad's address with --lpip", heartbeatPort))
+		}
+		return anns
+	}
+	anns := make(chan libplumraw.LightpadAnnouncement, 1)
+	anns <- libplumraw.LightpadAnnouncement{ID: "rrr", IP: net.ParseIP("192.168.1.10"), Port: 8443}
+	go func() {
+		<-ctx.Done()
+		close(anns)
+	}()
+	return anns
+}
+
+// heartbeatBindError explains why the heartbeat port couldn't be bound.
+func heartbeatBindError(err error) error {
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return fmt.Errorf("couldn't listen for Lightpad heartbeats: UDP port %d is already in use, probably by another plumcli or Plum app; stop it, or give the pad's address with --lpip", heartbeatPort)
+	}
+	return fmt.Errorf("couldn't listen for Lightpad heartbeats on UDP port %d: %s; give the pad's address with --lpip", heartbeatPort, err)
+}
+
+// runDiscover listens for Lightpad heartbeats for --discover-for and prints
+// each pad the first time it's heard.
+func runDiscover(options Options) {
+	ctx, cancel := context.WithTimeout(context.Background(), options.DiscoverFor)
+	defer cancel()
+	seen := make(map[string]bool)
+	var pads []libplumraw.LightpadAnnouncement
+	for ann := range listenHeartbeats(ctx, options) {
+		if seen[ann.ID] {
+			continue
+		}
+		seen[ann.ID] = true
+		pads = append(pads, ann)
+		if !jsonOutput() {
+			fmt.Printf("%s\t%d\t%s\n", ann.IP, ann.Port, ann.ID)
+		}
+	}
+	if jsonOutput() {
+		printResult(pads)
+	}
+	checkEmpty(len(pads), options.AllowEmptyResults)
+}
+
+// resolvesPadAddress reports whether a Lightpad action was given a Lightpad ID
+// instead of an --lpip, so the pad's address has to be looked up. idKind is
+// the kind of entity the ID is known to be; an ID that isn't explicitly a
+// Lightpad's, from --lightpad-id or --name, is never taken for one.
+func resolvesPadAddress(options Options, idKind string) bool {
+	if options.TestMode || options.All || options.LightpadIP != "" || options.ID == "" || idKind != "lightpad" {
+		return false
+	}
+	return actions[options.Action].needsFlag("lpip")
+}
+
+// resolvePadAddress sets --lpip and --port from the heartbeat of the Lightpad
+// whose ID is in --id, or from where it was last heard if that's cached. When conn is not nil the pad is first looked up in the
+// web API, which confirms the ID and swaps it for the pad's Logical Load ID,
+// which is what the Lightpad actions expect in --id.
+func resolvePadAddress(conn libplumraw.WebConnection, options *Options) error {
+	lpid := options.ID
+	if conn != nil {
+		pad, err := conn.GetLightpad(lpid)
+		if err != nil {
+			return fmt.Errorf("couldn't look up Lightpad %s: %s", lpid, err)
+		}
+		options.ID = pad.LLID
+	}
+	if addr, ok := cachedPadAddress(lpid, *options); ok {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Using the cached address %s:%d for Lightpad %s (--refresh-discovery to listen afresh)\n", addr.IP, addr.Port, lpid)
+		}
+		options.LightpadIP, options.Port = addr.IP, addr.Port
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), options.DiscoverFor)
+	defer cancel()
+	for ann := range listenHeartbeats(ctx, *options) {
+		if ann.ID == lpid {
+			options.LightpadIP = ann.IP.String()
+			options.Port = ann.Port
+			if options.DiscoveryTTL > 0 && !options.TestMode {
+				addr := padAddress{IP: options.LightpadIP, Port: options.Port, Heard: time.Now()}
+				if err := cachePadAddress(lpid, addr); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to cache the address of Lightpad %s: %s\n", lpid, err)
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("didn't hear Lightpad %s announce itself within %s; give its address with --lpip", lpid, options.DiscoverFor)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestHeartbeatBindError(t *testing.T) {
+	held, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	_, err = net.ListenPacket("udp4", held.LocalAddr().String())
+	if err == nil {
+		t.Fatal("bound a port that's already bound")
+	}
+	msg := heartbeatBindError(err).Error()
+	if !strings.Contains(msg, "43770 is already in use") || !strings.Contains(msg, "--lpip") {
+		t.Errorf("heartbeatBindError(%v) = %q", err, msg)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// prettyErrors, verbose, unreachableExitCode and nullOnNotFound control how
+// checkError reports failures; they are set from the command line flags.
+var (
+	prettyErrors        bool
+	verbose             bool
+	unreachableExitCode = 1
+	// nullOnNotFound has a read action print a JSON null for an entity
+	// that doesn't exist, rather than fail
+	nullOnNotFound bool
+)
+
+var uuidRE = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// errorHint describes a class of error we know how to explain better than
+// the raw message from libplumraw does.
+type errorHint struct {
+	matches func(err error) bool
+	message string
+	hint    string
+}
+
+var errorHints = []errorHint{
+	{
+		matches: func(err error) bool {
+			status := httpStatus(err)
+			return status == http.StatusUnauthorized || status == http.StatusForbidden
+		},
+		message: "authentication failed",
+		hint:    "check --email and --password for web actions, or run GetHouse to obtain a current HAT for Lightpad actions",
+	},
+	{
+		matches: isUnreachable,
+		message: "could not reach the server",
+		hint:    "check --lpip and --port are correct and the Lightpad is reachable on your LAN",
+	},
+	{
+		matches: func(err error) bool {
+			return httpStatus(err) == http.StatusNotFound
+		},
+		message: "no entity with that ID was found",
+		hint:    "check --id is a UUID returned by GetHouses, GetHouse or GetRoom",
+	},
+	{
+		matches: func(err error) bool {
+			return httpStatus(err) == http.StatusBadRequest
+		},
+		message: "the request was rejected as invalid",
+		hint:    "check --id is a well formed UUID and --conf is valid JSON",
+	},
+}
+
+// statusRE finds the HTTP status in an error's text, either after the word
+// "status" or as a response's status line, like "404 Not Found". Bare
+// numbers aren't trusted, since IDs, URLs and ports are full of them.
+var statusRE = regexp.MustCompile(`(?i)\bstatus(?: code)?:? *(\d{3})\b|\b(\d{3}) (?:unauthorized|forbidden|not found|bad request)\b`)
+
+// httpStatus returns the HTTP status err reports, or 0 if it doesn't report
+// one.
+func httpStatus(err error) int {
+	m := statusRE.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	code := m[1]
+	if code == "" {
+		code = m[2]
+	}
+	status, _ := strconv.Atoi(code)
+	return status
+}
+
+// explainError returns a friendlier description of err and a one line hint
+// on how to fix it, if err is one we recognize.
+func explainError(err error) (string, string, bool) {
+	for _, eh := range errorHints {
+		if eh.matches(err) {
+			return eh.message, eh.hint, true
+		}
+	}
+	return "", "", false
+}
+
+// isUnreachable reports whether err came from failing to connect to the
+// server at all, as opposed to the server rejecting the request: the
+// connection was refused, there was no route to it, or it timed out.
+func isUnreachable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errorContains(err, "connection refused", "no route to host", "i/o timeout", "network is unreachable")
+}
+
+func errorContains(err error, substrs ...string) bool {
+	msg := strings.ToLower(err.Error())
+	for _, substr := range substrs {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	return false
+}
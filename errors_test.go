@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	statuses := map[string]int{
+		"request failed with status 401":                                 401,
+		"GetHouse: 404 Not Found":                                        404,
+		"unexpected status code: 400":                                    400,
+		"no house 4010-4030-4040 at https://production.plum.technology/": 0,
+		"dial tcp 10.0.0.5:8403: i/o timeout":                            0,
+		"invalid character 'x' looking for beginning of value":           0,
+	}
+	for msg, want := range statuses {
+		if got := httpStatus(errors.New(msg)); got != want {
+			t.Errorf("httpStatus(%q) = %d, want %d", msg, got, want)
+		}
+	}
+}
+
+func TestIsUnreachable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	if !isUnreachable(fmt.Errorf("subscribe: %w", refused)) {
+		t.Error("a refused connection isn't unreachable")
+	}
+	// a connection that was made and then reset is the pad's doing
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	if isUnreachable(reset) {
+		t.Error("a reset connection is unreachable")
+	}
+}
+
+func TestNullOnNotFound(t *testing.T) {
+	nullOnNotFound, watching = true, true
+	defer func() { nullOnNotFound, watching = false, false }()
+	failures := summary.Failures
+	// under --watch, stop ends just the one run; a recovered watchAbort
+	// shows failOnError got that far
+	ended := func(err error) (aborted bool) {
+		defer func() { _, aborted = recover().(watchAbort) }()
+		failOnError(err, 1)
+		return false
+	}
+	if !ended(errors.New("GetRoom: 404 Not Found")) || summary.Failures != failures {
+		t.Error("a 404 wasn't printed as null")
+	}
+	if !ended(errors.New("GetRoom: 500 Internal Server Error")) || summary.Failures != failures+1 {
+		t.Error("a 500 wasn't recorded as a failure")
+	}
+}
+
+func TestIsParseError(t *testing.T) {
+	var v struct{ Level int }
+	typeErr := json.Unmarshal([]byte(`{"level": "high"}`), &v)
+	if !isParseError(fmt.Errorf("GetLightpad: %w", typeErr)) {
+		t.Errorf("%v isn't a parse error", typeErr)
+	}
+	// only the decoder's own errors count, not anything that reads like one
+	if isParseError(errors.New("invalid character in house name")) {
+		t.Error("a message mentioning an invalid character is a parse error")
+	}
+	if isParseError(&confError{typeErr}) {
+		t.Error("a bad --conf is a parse error")
+	}
+}
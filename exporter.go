@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// exporter holds what the Exporter action has learned about a load, for
+// /metrics to report in the Prometheus text format.
+type exporter struct {
+	llid string
+
+	mu         sync.Mutex
+	metrics    libplumraw.LogicalLoadMetrics
+	up         bool
+	pollErrors int
+	events     map[string]int
+}
+
+// poll fetches the load's metrics every interval for as long as it runs.
+func (e *exporter) poll(lp lightpad, interval time.Duration) {
+	for {
+		mets, err := lp.GetLogicalLoadMetrics()
+		e.mu.Lock()
+		if err != nil {
+			e.up = false
+			e.pollErrors++
+			fmt.Fprintf(os.Stderr, "Warning: failed to poll load metrics: %s\n", err)
+		} else {
+			e.up = true
+			e.metrics = mets
+		}
+		e.mu.Unlock()
+		time.Sleep(interval)
+	}
+}
+
+// count tallies each event heard from the pad by type.
+func (e *exporter) count(events <-chan padEvent) {
+	for pe := range events {
+		eventType, _ := eventValue(pe.ev)
+		e.mu.Lock()
+		e.events[eventType]++
+		e.mu.Unlock()
+	}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	up := 0
+	if e.up {
+		up = 1
+	}
+	fmt.Fprintln(w, "# HELP plum_up Whether the last poll of the Lightpad succeeded.")
+	fmt.Fprintln(w, "# TYPE plum_up gauge")
+	fmt.Fprintf(w, "plum_up{llid=%q} %d\n", e.llid, up)
+	fmt.Fprintln(w, "# HELP plum_poll_errors_total Polls of the Lightpad that failed.")
+	fmt.Fprintln(w, "# TYPE plum_poll_errors_total counter")
+	fmt.Fprintf(w, "plum_poll_errors_total{llid=%q} %d\n", e.llid, e.pollErrors)
+	if e.up {
+		fmt.Fprintln(w, "# HELP plum_load_level Dim level of the load, 0 (off) to 255 (full).")
+		fmt.Fprintln(w, "# TYPE plum_load_level gauge")
+		fmt.Fprintf(w, "plum_load_level{llid=%q} %d\n", e.llid, e.metrics.Level)
+		fmt.Fprintln(w, "# HELP plum_load_power_watts Power drawn by the load.")
+		fmt.Fprintln(w, "# TYPE plum_load_power_watts gauge")
+		fmt.Fprintf(w, "plum_load_power_watts{llid=%q} %d\n", e.llid, e.metrics.Power)
+		fmt.Fprintln(w, "# HELP plum_lightpad_power_watts Power drawn through each Lightpad of the load.")
+		fmt.Fprintln(w, "# TYPE plum_lightpad_power_watts gauge")
+		for _, pad := range e.metrics.LightpadMetrics {
+			fmt.Fprintf(w, "plum_lightpad_power_watts{llid=%q,lpid=%q} %d\n", e.llid, pad.LPID, pad.Power)
+		}
+	}
+	fmt.Fprintln(w, "# HELP plum_events_total Events heard from the Lightpad, by type.")
+	fmt.Fprintln(w, "# TYPE plum_events_total counter")
+	types := make([]string, 0, len(e.events))
+	for t := range e.events {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(w, "plum_events_total{llid=%q,type=%q} %d\n", e.llid, t, e.events[t])
+	}
+}
+
+// runExporter serves Prometheus metrics for the load on --listen: its level
+// and power, polled every --interval, and counts of the events it sends.
+func runExporter(options Options) {
+	stateChanges := make(chan libplumraw.Event, 0)
+	lp := newLightpad(options, stateChanges)
+	err := lp.Subscribe(context.Background())
+	checkPadError(err)
+
+	e := &exporter{
+		llid:   options.ID,
+		events: make(map[string]int),
+	}
+	events := make(chan padEvent)
+	go func() {
+		listen(options, nil, stateChanges, events)
+		close(events)
+	}()
+	go e.count(events)
+	// the subscription's pad is replaced if it reconnects, so poll through
+	// a pad of our own
+	go e.poll(newLightpad(options, nil), options.Interval)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	fmt.Printf("serving metrics for %s on %s/metrics\n", options.ID, options.Listen)
+	checkError(http.ListenAndServe(options.Listen, mux))
+}
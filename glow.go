@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// glowColors are the names --color accepts besides hex RGB.
+var glowColors = map[string]libplumraw.ForceGlow{
+	"white":  {White: 255},
+	"red":    {Red: 255},
+	"green":  {Green: 255},
+	"blue":   {Blue: 255},
+	"yellow": {Red: 255, Green: 255},
+	"cyan":   {Green: 255, Blue: 255},
+	"purple": {Red: 255, Blue: 255},
+	"orange": {Red: 255, Green: 128},
+}
+
+// parseColor turns a --color of a name from glowColors or hex RGB such as
+// #ff8000 into the glow's color channels.
+func parseColor(color string) (libplumraw.ForceGlow, error) {
+	if glow, ok := glowColors[strings.ToLower(color)]; ok {
+		return glow, nil
+	}
+	hex := strings.TrimPrefix(color, "#")
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return libplumraw.ForceGlow{}, fmt.Errorf("--color %q should be a hex color like #ff8000 or one of white, red, green, blue, yellow, cyan, purple or orange", color)
+	}
+	return libplumraw.ForceGlow{
+		Red:   int(rgb >> 16 & 0xff),
+		Green: int(rgb >> 8 & 0xff),
+		Blue:  int(rgb & 0xff),
+	}, nil
+}
+
+// glowFromOptions builds the glow for the load in --id, starting from base,
+// then any --conf JSON, then --color, --intensity and --timeout, and checks
+// that the result is one the pad will accept. If required, one of --conf or
+// --color must have been given.
+func glowFromOptions(options Options, base libplumraw.ForceGlow, required bool) (libplumraw.ForceGlow, error) {
+	glow := base
+	if required && options.Conf == "" && options.Color == "" {
+		return glow, fmt.Errorf("%s needs the glow, as --color or --conf JSON", options.Action)
+	}
+	if options.Conf != "" {
+		if err := unmarshalConf(options.Conf, &glow); err != nil {
+			return glow, err
+		}
+	}
+	if options.Color != "" {
+		color, err := parseColor(options.Color)
+		if err != nil {
+			return glow, err
+		}
+		glow.Red, glow.Green, glow.Blue, glow.White = color.Red, color.Green, color.Blue, color.White
+		if glow.Intensity == 0 {
+			glow.Intensity = 100
+		}
+	}
+	if options.Intensity >= 0 {
+		glow.Intensity = options.Intensity
+	}
+	if options.GlowTimeout > 0 {
+		glow.Timeout = int(options.GlowTimeout / time.Millisecond)
+	}
+	glow.LLID = options.ID
+	return glow, validateGlow(glow)
+}
+
+// validateGlow checks the fields of glow are in the ranges the pad accepts.
+func validateGlow(glow libplumraw.ForceGlow) error {
+	channels := []struct {
+		name  string
+		value int
+	}{
+		{"red", glow.Red},
+		{"green", glow.Green},
+		{"blue", glow.Blue},
+		{"white", glow.White},
+	}
+	for _, ch := range channels {
+		if ch.value < 0 || ch.value > 255 {
+			return fmt.Errorf("glow %s must be from 0 to 255, not %d", ch.name, ch.value)
+		}
+	}
+	if glow.Intensity < 0 || glow.Intensity > 100 {
+		return fmt.Errorf("glow intensity must be from 0 to 100, not %d", glow.Intensity)
+	}
+	if glow.Timeout < 0 {
+		return fmt.Errorf("glow timeout can't be negative")
+	}
+	return nil
+}
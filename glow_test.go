@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+func TestParseColor(t *testing.T) {
+	colors := map[string]libplumraw.ForceGlow{
+		"red":     {Red: 255},
+		"Orange":  {Red: 255, Green: 128},
+		"#ff8000": {Red: 255, Green: 128},
+		"00FF7f":  {Green: 255, Blue: 127},
+	}
+	for color, want := range colors {
+		got, err := parseColor(color)
+		if err != nil || got != want {
+			t.Errorf("parseColor(%q) = %+v, %v; want %+v", color, got, err, want)
+		}
+	}
+	for _, color := range []string{"#ff80", "#gg8000", "mauve", ""} {
+		if _, err := parseColor(color); err == nil {
+			t.Errorf("parseColor(%q) succeeded, want an error", color)
+		}
+	}
+}
+
+func TestGlowFromOptions(t *testing.T) {
+	options := Options{
+		Action:      "SetLoadGlow",
+		ID:          "llid",
+		Conf:        `{"intensity": 20, "white": 255, "timeout": 1000}`,
+		Color:       "blue",
+		Intensity:   -1,
+		GlowTimeout: 3 * time.Second,
+	}
+	glow, err := glowFromOptions(options, libplumraw.ForceGlow{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// --color replaces every channel from --conf, but keeps its intensity;
+	// --timeout overrides the one in --conf
+	want := libplumraw.ForceGlow{LLID: "llid", Intensity: 20, Blue: 255, Timeout: 3000}
+	if glow != want {
+		t.Errorf("glow = %+v, want %+v", glow, want)
+	}
+
+	options.Intensity = 101
+	if _, err := glowFromOptions(options, libplumraw.ForceGlow{}, true); err == nil {
+		t.Error("--intensity 101 was accepted")
+	}
+
+	if _, err := glowFromOptions(Options{Intensity: -1}, libplumraw.ForceGlow{}, true); err == nil {
+		t.Error("a required glow with neither --conf nor --color was accepted")
+	}
+	glow, err = glowFromOptions(Options{Intensity: -1}, libplumraw.ForceGlow{Intensity: 100, White: 255}, false)
+	if err != nil || glow.White != 255 {
+		t.Errorf("optional glow = %+v, %v; want the base glow", glow, err)
+	}
+}
+
+func TestValidateGlow(t *testing.T) {
+	bad := []libplumraw.ForceGlow{
+		{Red: 256},
+		{Green: -1},
+		{Intensity: 101},
+		{Timeout: -1},
+	}
+	for _, glow := range bad {
+		if validateGlow(glow) == nil {
+			t.Errorf("validateGlow(%+v) passed", glow)
+		}
+	}
+	if err := validateGlow(libplumraw.ForceGlow{Red: 255, Intensity: 100}); err != nil {
+		t.Error(err)
+	}
+}
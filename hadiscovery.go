@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// haDevice groups the entities of one logical load under a single device in
+// Home Assistant.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// haLight is the discovery config for the load as a dimmable light. HA sends
+// ON/OFF to the command topic and brightness, 0 to 255 like the Lightpad's
+// own level, to the brightness command topic.
+type haLight struct {
+	Name                   string   `json:"name"`
+	UniqueID               string   `json:"unique_id"`
+	CommandTopic           string   `json:"command_topic"`
+	StateTopic             string   `json:"state_topic"`
+	BrightnessCommandTopic string   `json:"brightness_command_topic"`
+	BrightnessStateTopic   string   `json:"brightness_state_topic"`
+	BrightnessScale        int      `json:"brightness_scale"`
+	OnCommandType          string   `json:"on_command_type"`
+	AvailabilityTopic      string   `json:"availability_topic"`
+	Device                 haDevice `json:"device"`
+}
+
+// haSensor is the discovery config for the load's power draw.
+type haSensor struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	UnitOfMeasurement string   `json:"unit_of_measurement"`
+	DeviceClass       string   `json:"device_class"`
+	StateClass        string   `json:"state_class"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	Device            haDevice `json:"device"`
+}
+
+// haDiscoveryConfigs returns the retained messages, by topic, that make
+// Home Assistant pick up the load Bridge is relaying as a light with a power
+// sensor. topic gives the Bridge topic for a name like "level".
+func haDiscoveryConfigs(options Options, topic func(name string) string) (map[string][]byte, error) {
+	name := options.HAName
+	if name == "" {
+		name = "Plum " + options.ID
+	}
+	id := "plum_" + options.ID
+	device := haDevice{
+		Identifiers:  []string{id},
+		Name:         name,
+		Manufacturer: "Plum",
+		Model:        "Lightpad",
+	}
+	configs := map[string]interface{}{
+		strings.Join([]string{options.HAPrefix, "light", options.ID, "config"}, "/"): haLight{
+			Name:                   name,
+			UniqueID:               id + "_light",
+			CommandTopic:           topic("set_state"),
+			StateTopic:             topic("state"),
+			BrightnessCommandTopic: topic("set_level"),
+			BrightnessStateTopic:   topic("level"),
+			BrightnessScale:        255,
+			// send just the brightness when turning on, so the light comes
+			// on at the level asked for rather than full and then dimming
+			OnCommandType:     "brightness",
+			AvailabilityTopic: topic("available"),
+			Device:            device,
+		},
+		strings.Join([]string{options.HAPrefix, "sensor", options.ID, "power", "config"}, "/"): haSensor{
+			Name:              name + " Power",
+			UniqueID:          id + "_power",
+			StateTopic:        topic("power"),
+			UnitOfMeasurement: "W",
+			DeviceClass:       "power",
+			StateClass:        "measurement",
+			AvailabilityTopic: topic("available"),
+			Device:            device,
+		},
+	}
+	msgs := make(map[string][]byte, len(configs))
+	for t, config := range configs {
+		buf, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		msgs[t] = buf
+	}
+	return msgs, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// hatCachePath is where House Access Tokens are remembered between runs,
+// keyed by house ID.
+func hatCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plumcliraw", "hat.json"), nil
+}
+
+// readHATCache returns the cached HATs, or an empty cache if there isn't one
+// yet.
+func readHATCache() (map[string]string, error) {
+	hats := make(map[string]string)
+	path, err := hatCachePath()
+	if err != nil {
+		return hats, err
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return hats, nil
+	}
+	if err != nil {
+		return hats, err
+	}
+	if err := json.Unmarshal(buf, &hats); err != nil {
+		return hats, fmt.Errorf("failed to parse HAT cache %s: %s", path, err)
+	}
+	return hats, nil
+}
+
+// cacheHAT remembers hat as the access token for house hid. The file only
+// holds secrets, so it's kept readable by its owner alone.
+func cacheHAT(hid, hat string) error {
+	hats, err := readHATCache()
+	if err != nil {
+		return err
+	}
+	if hats[hid] == hat {
+		return nil
+	}
+	hats[hid] = hat
+	path, err := hatCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(hats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0600)
+}
+
+// cachedHAT returns the cached HAT for house hid. With no hid it returns the
+// only cached HAT, and an error if there are HATs for several houses to
+// choose from. It returns "" if nothing suitable is cached.
+func cachedHAT(hid string) (string, error) {
+	hats, err := readHATCache()
+	if err != nil {
+		return "", err
+	}
+	if hid != "" {
+		return hats[hid], nil
+	}
+	if len(hats) > 1 {
+		hids := make([]string, 0, len(hats))
+		for hid := range hats {
+			hids = append(hids, hid)
+		}
+		sort.Strings(hids)
+		return "", fmt.Errorf("HATs are cached for several houses (%s); pick one with --hat-house or pass --hat", strings.Join(hids, ", "))
+	}
+	for _, hat := range hats {
+		return hat, nil
+	}
+	return "", nil
+}
+
+// fetchHATs looks up every house so that their HATs end up in the cache.
+func fetchHATs(conn libplumraw.WebConnection) error {
+	houses, err := conn.GetHouses()
+	if err != nil {
+		return err
+	}
+	for _, hid := range houses {
+		if _, err := conn.GetHouse(hid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hatRecorder is a WebConnection that caches the HAT of every house it
+// fetches.
+type hatRecorder struct {
+	libplumraw.WebConnection
+}
+
+func (h hatRecorder) GetHouse(hid string) (libplumraw.House, error) {
+	house, err := h.WebConnection.GetHouse(hid)
+	if err == nil && house.AccessToken != "" {
+		if err := cacheHAT(house.ID, house.AccessToken); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to cache the HAT for house %s: %s\n", house.ID, err)
+		}
+	}
+	return house, err
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// padHATs maps Lightpad IDs and IP addresses to the HAT each pad takes, from
+// --hats, for houses whose pads don't all share one token.
+var padHATs map[string]string
+
+// readHATs reads the --hats mapping, given as JSON or as @file.
+func readHATs(hats string) (map[string]string, error) {
+	if hats == "" {
+		return nil, nil
+	}
+	buf := []byte(hats)
+	if strings.HasPrefix(hats, "@") {
+		var err error
+		buf, err = ioutil.ReadFile(strings.TrimPrefix(hats, "@"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --hats %s: %s", hats, err)
+		}
+	}
+	m := make(map[string]string)
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil, fmt.Errorf("--hats must be a JSON object mapping Lightpad IDs or IP addresses to HATs: %s", err)
+	}
+	return m, nil
+}
+
+// padHAT returns the HAT --hats gives the pad at ip, or else the one it gives
+// the pad with ID lpid, or else hat, the one from --hat.
+func padHAT(ip, lpid, hat string) string {
+	if h, ok := padHATs[ip]; ok && ip != "" {
+		return h
+	}
+	if h, ok := padHATs[lpid]; ok && lpid != "" {
+		return h
+	}
+	return hat
+}
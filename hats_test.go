@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestPadHAT(t *testing.T) {
+	var err error
+	padHATs, err = readHATs(`{"10.0.0.1": "hat-by-ip", "lp2": "hat-by-id"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { padHATs = nil }()
+
+	pads := subscribePads(Options{LightpadIP: "10.0.0.1,10.0.0.2,10.0.0.3", HAT: "shared"})
+	for i, want := range []string{"hat-by-ip", "shared", "shared"} {
+		if pads[i].HAT != want {
+			t.Errorf("pad %s got HAT %q, want %q", pads[i].LightpadIP, pads[i].HAT, want)
+		}
+	}
+	// a pad found by its ID, as --all and --lightpad-id do
+	if got := padHAT("10.0.0.9", "lp2", "shared"); got != "hat-by-id" {
+		t.Errorf("padHAT by ID = %q, want hat-by-id", got)
+	}
+
+	if _, err := readHATs(`["hat"]`); err == nil {
+		t.Error("readHATs accepted a list")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// LatLong names the anonymous struct libplumraw uses for House.LatLong. The
+// fields and tags must stay identical to libplumraw's so the two types remain
+// assignable to each other.
+type LatLong struct {
+	Latitude  float64 `json:"latitude_degrees_north,omitempty"` // decimal degrees North
+	Longitude float64 `json:"longitude_degrees_west,omitempty"` // decimal degrees West
+}
+
+func (l LatLong) String() string {
+	return fmt.Sprintf("%.4f°N %.4f°W", l.Latitude, l.Longitude)
+}
+
+// houseLocation is where a House is and what time zone it keeps.
+type houseLocation struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	LatLong  LatLong `json:"location"`
+	TimeZone int     `json:"time_zone"`
+}
+
+func newHouseLocation(house libplumraw.House) houseLocation {
+	return houseLocation{
+		ID:       house.ID,
+		Name:     house.Name,
+		LatLong:  LatLong(house.LatLong),
+		TimeZone: house.TimeZone,
+	}
+}
+
+func (h houseLocation) String() string {
+	return fmt.Sprintf("House %s (%s)\n  Location:  %s\n  Time zone: %d\n", h.Name, h.ID, h.LatLong, h.TimeZone)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// resolveIDAliases copies whichever typed ID flag (--house-id, --room-id,
+// etc.) was given into options.ID and returns the kind of entity it named, or
+// "" if only the generic --id was used. An alias for a different kind of
+// entity than the action takes is an error.
+func resolveIDAliases(options *Options) (string, error) {
+	aliases := []struct {
+		kind string
+		id   string
+	}{
+		{"house", options.HouseID},
+		{"room", options.RoomID},
+		{"load", options.LoadID},
+		{"lightpad", options.LightpadID},
+		{"scene", options.SceneID},
+	}
+	var kind string
+	for _, alias := range aliases {
+		if alias.id == "" {
+			continue
+		}
+		if spec, ok := actions[options.Action]; ok {
+			want := nameKind(*options)
+			// a Lightpad ID can stand in for the address of the pad
+			if alias.kind != want && !(alias.kind == "lightpad" && spec.needsFlag("lpip")) {
+				if want == "" {
+					return "", fmt.Errorf("%s doesn't take an ID, so --%s-id can't be used with it", options.Action, alias.kind)
+				}
+				return "", fmt.Errorf("--%s-id can't be used with %s, which takes a %s ID", alias.kind, options.Action, want)
+			}
+		}
+		if options.ID != "" && options.ID != alias.id {
+			return "", fmt.Errorf("--%s-id %s conflicts with ID %s given by another flag", alias.kind, alias.id, options.ID)
+		}
+		options.ID = alias.id
+		kind = alias.kind
+	}
+	return kind, nil
+}
+
+// validateID checks that id really is the ID of a kind entity by fetching it.
+func validateID(conn libplumraw.WebConnection, kind, id string) error {
+	var err error
+	switch kind {
+	case "house":
+		_, err = conn.GetHouse(id)
+	case "room":
+		_, err = conn.GetRoom(id)
+	case "load":
+		_, err = conn.GetLogicalLoad(id)
+	case "lightpad":
+		_, err = conn.GetLightpad(id)
+	case "scene":
+		_, err = conn.GetScene(id)
+	}
+	if err != nil {
+		return fmt.Errorf("%s is not a valid %s ID: %s", id, kind, err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// configChange is one field that differs between two Lightpad configs. A nil
+// value means the field isn't set in that config.
+type configChange struct {
+	Field    string      `json:"field"`
+	Current  interface{} `json:"current"`
+	Proposed interface{} `json:"proposed"`
+}
+
+// configFields returns conf as the fields it marshals to.
+func configFields(conf libplumraw.LightpadConfig) (map[string]interface{}, error) {
+	buf, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	err = json.Unmarshal(buf, &fields)
+	return fields, err
+}
+
+// diffLightpadConfig returns the fields that differ between current and
+// proposed, sorted by name.
+func diffLightpadConfig(current, proposed libplumraw.LightpadConfig) ([]configChange, error) {
+	cur, err := configFields(current)
+	if err != nil {
+		return nil, err
+	}
+	prop, err := configFields(proposed)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for name := range cur {
+		names[name] = true
+	}
+	for name := range prop {
+		names[name] = true
+	}
+	var changes []configChange
+	for name := range names {
+		if !reflect.DeepEqual(cur[name], prop[name]) {
+			changes = append(changes, configChange{name, cur[name], prop[name]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// runDiffLightpadConfig prints how the config in --conf differs from the
+// current config of the Lightpad in --id.
+func runDiffLightpadConfig(conn libplumraw.WebConnection, options Options) {
+	pad, err := conn.GetLightpad(options.ID)
+	checkError(err)
+	proposed := libplumraw.LightpadConfig{}
+	err = unmarshalConf(options.Conf, &proposed)
+	checkError(err)
+	changes, err := diffLightpadConfig(pad.Config, proposed)
+	checkError(err)
+	if jsonOutput() {
+		printResult(changes)
+		return
+	}
+	if len(changes) == 0 {
+		fmt.Printf("Lightpad %s already has this config\n", options.ID)
+		return
+	}
+	for _, c := range changes {
+		fmt.Printf("%s: %s -> %s\n", c.Field, configValue(c.Current), configValue(c.Proposed))
+	}
+}
+
+// configValue formats one side of a configChange.
+func configValue(v interface{}) string {
+	if v == nil {
+		return "(unset)"
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(buf)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maplebed/libplumraw"
+)
+
+func TestDiffLightpadConfig(t *testing.T) {
+	current := libplumraw.LightpadConfig{GlowIntensity: 0.5, GlowEnabled: true}
+
+	changes, err := diffLightpadConfig(current, current)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("diff against itself = %+v, %v; want no changes", changes, err)
+	}
+
+	changes, err = diffLightpadConfig(current, libplumraw.LightpadConfig{GlowIntensity: 0.8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a field left out of the proposed config shows up as nil, and fields
+	// come sorted by name
+	want := []configChange{
+		{Field: "glowEnabled", Current: true, Proposed: nil},
+		{Field: "glowIntensity", Current: 0.5, Proposed: 0.8},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %+v, want %+v", changes, want)
+	}
+}
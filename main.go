@@ -1,32 +1,146 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/davecgh/go-spew/spew"
+	"github.com/itchyny/gojq"
 	flag "github.com/jessevdk/go-flags"
 	"github.com/maplebed/libplumraw"
+	"golang.org/x/term"
 )
 
 type Options struct {
-	Email    string `short:"e" long:"email" descrption:"Email address to authenticate with the Plum Web API"`
-	Password string `short:"p" long:"password" descrption:"Password to authenticate with the Plum Web API"`
-	ID       string `long:"id" description:"For commands that require an ID, use this flag to set it"`
+	Config        string `long:"config" description:"Config file of defaults for email, password, hat, lpip, port and output; defaults to ~/.plumcliraw.yaml, .yml or .toml"`
+	Email         string `short:"e" long:"email" env:"PLUM_EMAIL" descrption:"Email address to authenticate with the Plum Web API"`
+	Password      string `short:"p" long:"password" env:"PLUM_PASSWORD" descrption:"Password to authenticate with the Plum Web API"`
+	PasswordStdin bool   `long:"password-stdin" description:"Read the Plum Web API password from the first line of stdin; without this or --password you are prompted for it"`
+	ID            string `long:"id" description:"For commands that require an ID, use this flag to set it"`
+	Name          string `long:"name" description:"Name (or unique prefix or part of the name) of the House, Room, Load, Lightpad or Scene to use in place of --id"`
 
-	LightpadIP string `long:"lpip" description:"Lightpad IP Address"`
-	Port       int    `long:"port" description:"Lightpad Port" default:"8443"`
-	HAT        string `long:"hat" description:"House Access Token - get from --action GetHouse"`
-	Conf       string `long:"conf" description:"JSON used for Lightpad Set commands"`
+	HouseID    string `long:"house-id" description:"House ID; an alias for --id that documents the ID's type"`
+	RoomID     string `long:"room-id" description:"Room ID; an alias for --id that documents the ID's type"`
+	LoadID     string `long:"load-id" description:"Logical Load ID; an alias for --id that documents the ID's type"`
+	LightpadID string `long:"lightpad-id" description:"Lightpad ID; an alias for --id that documents the ID's type"`
+	SceneID    string `long:"scene-id" description:"Scene ID; an alias for --id that documents the ID's type"`
+	ValidateID bool   `long:"validate-id" description:"Check that an ID given with a typed alias like --room-id really is that type"`
 
+	LightpadIP string `long:"lpip" env:"PLUM_LPIP" description:"Lightpad IP Address; Subscribe takes a comma separated list, with a matching list of --id"`
+	Port       int    `long:"port" env:"PLUM_PORT" description:"Lightpad Port" default:"8443"`
+	HAT        string `long:"hat" env:"PLUM_HAT" description:"House Access Token - get from --action GetHouse, which also caches it for when --hat isn't given"`
+	HATs       string `long:"hats" description:"JSON object, or @file holding one, mapping Lightpad IDs or IP addresses to HATs for pads that don't take --hat"`
+	HATHouse   string `long:"hat-house" description:"House ID whose cached HAT to use when --hat isn't given and HATs for several houses are cached"`
+	Conf       string `long:"conf" description:"JSON used for Lightpad Set commands; prefix with base64: to pass it base64 encoded, give @<file> to read it from a file or - to read it from stdin"`
+	ValueOnly  bool   `long:"value-only" description:"GetLoadMetrics prints only the bare --value-field number"`
+	ValueField string `long:"value-field" description:"Metric printed by --value-only: power (watts) or level" default:"power"`
+	LevelStep  int    `long:"level-step" description:"Snap SetLevel to the nearest multiple of this step for pads with coarse dimming"`
+
+	BlinkCount    int           `long:"blink-count" description:"Number of times IdentifyLightpad flashes the glow ring" default:"5"`
+	BlinkInterval time.Duration `long:"blink-interval" description:"How long each IdentifyLightpad flash lasts" default:"500ms"`
+
+	DiscoverFor time.Duration `long:"discover-for" description:"How long Discover, or finding a Lightpad's address from its ID, listens for Lightpad heartbeats" default:"10s"`
+	Color       string        `long:"color" description:"Glow ring color for SetLoadGlow, GlowFor and IdentifyLightpad, as hex (#ff8000) or white, red, green, blue, yellow, cyan, purple or orange"`
+	Intensity   int           `long:"intensity" description:"Glow ring intensity from 0 to 100; with --color it defaults to 100" default:"-1" default-mask:"-"`
+	GlowTimeout time.Duration `long:"timeout" description:"How long the pad keeps a SetLoadGlow glow lit before clearing it"`
+	Duration    time.Duration `long:"duration" description:"How long GlowFor keeps the glow ring lit" default:"10s"`
+
+	DiscoveryTTL     time.Duration `long:"discovery-ttl" description:"How long a Lightpad's address, once heard, is cached on disk for finding it from its ID; 0 to not cache it" default:"24h"`
+	RefreshDiscovery bool          `long:"refresh-discovery" description:"Listen for the Lightpad's heartbeat even if its address is cached"`
+
+	Source   string        `long:"source" description:"MirrorLevel pad to follow, as llid/ip[:port]/hat"`
+	Target   string        `long:"target" description:"MirrorLevel pad to apply levels to, as llid/ip[:port]/hat"`
+	Invert   bool          `long:"invert" description:"MirrorLevel sets the target to the inverse of the source level"`
+	Debounce time.Duration `long:"debounce" description:"How long the MirrorLevel source must be still before its level is mirrored" default:"250ms"`
+
+	Retries            int           `long:"retries" description:"Number of times to retry a failed request" default:"2"`
+	RetryOnStatus      string        `long:"retry-on-status" description:"Comma separated HTTP status codes that should be retried" default:"429,500,502,503,504"`
+	BackoffBase        time.Duration `long:"backoff-base" description:"Delay before the first retry; doubles each retry up to --backoff-cap" default:"250ms"`
+	BackoffCap         time.Duration `long:"backoff-cap" description:"Longest delay between retries" default:"5s"`
+	RetryNonIdempotent bool          `long:"retry-non-idempotent" description:"Also retry actions that aren't safe to repeat, which could apply a change twice"`
+	RetryBudget        time.Duration `long:"retry-budget" description:"Cap on the total time the whole command spends retrying; 0 for no cap"`
+	RequestID          string        `long:"request-id" description:"ID to send in the X-Request-ID header of every request; a new UUID per request if unset (shown with --verbose)"`
+	PadTLSMinVersion   string        `long:"pad-tls-min-version" description:"Minimum TLS version (1.2 or 1.3) to accept from Lightpads; pads that can't negotiate it will fail to connect"`
+	Stats              bool          `long:"stats" description:"Print request count, bytes transferred, wall time and average latency on stderr at the end"`
+	SlowThreshold      time.Duration `long:"slow-threshold" description:"Warn on stderr about requests slower than this (eg 2s)"`
+
+	UnreachableExitCode int `long:"exit-code-on-unreachable" description:"Exit code to use when a Lightpad can't be reached" default:"1"`
+
+	Output      string `long:"output" description:"Print results as debug (Go values), json, or jsonl (one line of JSON; one line per event for Subscribe)" default:"debug"`
+	FormatLevel string `long:"format-level" description:"Print levels as raw (0-255), percent or both" default:"raw"`
+	Timezone    string `long:"timezone" description:"IANA time zone (eg America/Los_Angeles) to print timestamps in; defaults to local time"`
+
+	PrettyErrors bool `long:"pretty-errors" description:"Explain common errors and suggest how to fix them"`
+	Verbose      bool `short:"v" long:"verbose" description:"Print extra detail, such as the raw error behind a --pretty-errors message"`
+
+	Version     bool   `long:"version" description:"Print version information, including the libplumraw version"`
 	ListActions bool   `short:"l" long:"list_actions" description:"List available actions"`
 	Action      string `short:"a" long:"action" description:"Call to make to the API or Lgihtpad"`
 
+	Keepalive     time.Duration `long:"keepalive" description:"While subscribed, poll the Lightpad this often to keep the connection alive, and resubscribe if it dies; implies --max-reconnects -1 if that's left at 0"`
+	MaxReconnects int           `long:"max-reconnects" description:"When the Lightpad drops a subscription, reconnect with backoff (--backoff-base, --backoff-cap) up to this many times in a row without hearing an event; -1 for no limit"`
+	All           bool          `long:"all" description:"Subscribe to every Lightpad heard announcing itself within --discover-for, instead of --lpip"`
+
+	MQTT         string `long:"mqtt" description:"MQTT broker Bridge connects to, eg tcp://broker:1883"`
+	MQTTPrefix   string `long:"mqtt-prefix" description:"Topic prefix Bridge publishes under, as <prefix>/<llid>/power etc" default:"plum"`
+	MQTTUser     string `long:"mqtt-user" description:"Username to log in to the MQTT broker with"`
+	MQTTPassword string `long:"mqtt-password" env:"PLUM_MQTT_PASSWORD" description:"Password to log in to the MQTT broker with"`
+	HADiscovery  bool   `long:"ha-discovery" description:"Have Bridge publish Home Assistant MQTT discovery configs so the load appears as a light with a power sensor"`
+	HAPrefix     string `long:"ha-prefix" description:"Home Assistant's MQTT discovery prefix" default:"homeassistant"`
+	HAName       string `long:"ha-name" description:"Name for the load in Home Assistant; defaults to Plum <llid>"`
+
+	AllowEmptyResults bool          `long:"allow-empty-results" description:"Exit 0 when a list action finds nothing instead of exiting 5"`
+	TreeFile          string        `long:"tree-file" description:"Write the GetHouseTree JSON to this file instead of stdout"`
+	CacheTTL          time.Duration `long:"cache-ttl" description:"How long the topology fetched for GetHouseTree and --name is cached on disk; 0 to not cache it" default:"1h"`
+	Refresh           bool          `long:"refresh" description:"Fetch the topology afresh instead of using the cache"`
+	Resolve           bool          `long:"resolve" description:"Have GetScenes and GetHouseTree --include-scenes fetch each Scene and print its name alongside its ID"`
+	IncludeScenes     bool          `long:"include-scenes" description:"Have GetHouseTree list each House's Scene IDs"`
+
+	ConfWatch    string `long:"conf-watch" description:"Re-apply SetLightpadConfig or SetLoadConfig from this file every time it changes"`
+	ApplyOnStart bool   `long:"apply-on-start" description:"With --conf-watch, apply the file once at startup too"`
+
+	Since              time.Duration `long:"since" description:"Replay Subscribe events newer than this; currently a no-op, as Lightpads don't buffer events"`
+	MaxEventsPerSecond int           `long:"max-events-per-second" description:"While subscribed, drop events beyond this rate (0 for no limit)"`
+
+	EventsOnly         bool   `long:"events-only" description:"Only show discrete Subscribe events (dimmer changes, motion), not power telemetry"`
+	TelemetryOnly      bool   `long:"telemetry-only" description:"Only show Subscribe power telemetry, not discrete events"`
+	FailOnUnknownEvent bool   `long:"fail-on-unknown-event" description:"Exit non-zero the first time Subscribe hears an event type libplumraw doesn't understand"`
+	SQLite             string `long:"sqlite" description:"Also record Subscribe events in this SQLite database (created if needed)"`
+	Sink               string `long:"sink" description:"Also write GetLoadMetrics and Subscribe power and level readings as InfluxDB line protocol to influxdb://[user:pass@]host:port/db or to a file"`
+	EmitInitialState   bool   `long:"emit-initial-state" description:"When Subscribe starts, emit the load's current level and power as initial events"`
+	ConcurrentPadReads int    `long:"concurrent-pad-reads" description:"How many Lightpads --emit-initial-state reads at once" default:"8"`
+
+	MaxConcurrentSubscribes int `long:"max-concurrent-subscribes" description:"Most Lightpads Subscribe listens to at once; the rest wait until a subscription ends (0 for no limit)"`
+
+	PowerGlow bool `long:"power-glow" description:"While subscribed, color the glow ring from green to red by current power draw"`
+	WattsMax  int  `long:"watts-max" description:"Power draw at which --power-glow turns fully red" default:"300"`
+
+	Watch    bool          `long:"watch" description:"Re-run a read action every --interval, redrawing the screen like watch(1)"`
+	Interval time.Duration `long:"interval" description:"How often --watch re-runs the action, or Exporter polls the load" default:"2s"`
+	Listen   string        `long:"listen" description:"Address Exporter serves /metrics, or Serve its REST API, on; only this machine by default, as neither asks for credentials" default:"127.0.0.1:9743"`
+
+	MinInterval      time.Duration `long:"min-interval" description:"Shortest --interval, or Serve refresh interval, to poll at; shorter ones are raised to it with a warning" default:"1s"`
+	AllowFastPolling bool          `long:"allow-fast-polling" description:"Poll as often as asked, ignoring --min-interval"`
+
+	OutputNullOnError bool   `long:"output-null-on-error" description:"With JSON --output, have a read action print null and succeed when what it reads doesn't exist (a 404), instead of failing"`
+	StripNullFields   bool   `long:"strip-null-fields" description:"Leave null, empty and zero fields out of JSON --output"`
+	JSONArray         bool   `long:"json-array" description:"Print the lines of --output jsonl, such as Subscribe events or --watch runs, as one JSON array instead"`
+	JQ                string `long:"jq" description:"Run JSON --output through this jq program, printing each of its outputs"`
+
+	SummaryFile string `long:"summary-file" description:"Write a JSON summary of the run (action, success and failure counts, duration, errors) to this file"`
+
+	DryRun   bool `long:"dry-run" description:"Print the HTTP request (minus secrets) that SetLevel, SetLightpadConfig, SetLoadConfig or SetLoadGlow would send to the pad instead of sending it"`
+	NoAuth   bool `long:"no-auth" description:"Never use the Plum web API; fail early if the action would need --email and --password"`
 	TestMode bool `long:"test" description:"Run this CLI in Test mode"`
 }
 
@@ -35,9 +149,106 @@ const version = "0.0.1"
 func main() {
 	var options Options
 	flagParser := flag.NewParser(&options, flag.Default)
-	flagParser.Parse()
+	err := addCommands(flagParser, &options)
+	checkError(err)
+	if _, err := flagParser.Parse(); err != nil {
+		// go-flags has already printed the error, or the help that was asked for
+		if flagErr, ok := err.(*flag.Error); ok && flagErr.Type == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	err = applyConfig(flagParser, &options)
+	checkError(err)
 
 	libplumraw.UserAgentAddition = fmt.Sprintf("rawcli/%s", version)
+	prettyErrors = options.PrettyErrors
+	verbose = options.Verbose
+	unreachableExitCode = options.UnreachableExitCode
+	summaryFile = options.SummaryFile
+	summary.Action = options.Action
+	exitOnSignal(options)
+	if options.Conf == "-" && options.PasswordStdin {
+		fmt.Println("--conf - and --password-stdin can't both read stdin")
+		exit(1)
+	}
+	options.Conf, err = readConf(options.Conf)
+	checkError(err)
+	idKind, err := resolveIDAliases(&options)
+	checkError(err)
+	padHATs, err = readHATs(options.HATs)
+	checkError(err)
+	if options.UnreachableExitCode < 1 || options.UnreachableExitCode > 125 {
+		// 0 would report a dead pad as success, and shells reserve 126 up
+		fmt.Printf("--exit-code-on-unreachable must be from 1 to 125, not %d\n", options.UnreachableExitCode)
+		exit(1)
+	}
+	if options.LevelStep < 0 || options.LevelStep > 255 {
+		fmt.Printf("--level-step must be from 1 to 255, or 0 to not snap, not %d\n", options.LevelStep)
+		exit(1)
+	}
+	switch options.FormatLevel {
+	case "raw", "percent", "both":
+		levelFormat = options.FormatLevel
+	default:
+		fmt.Printf("--format-level must be raw, percent or both, not '%s'\n", options.FormatLevel)
+		exit(1)
+	}
+	switch options.Output {
+	case "debug", "json", "jsonl":
+		outputFormat = options.Output
+	default:
+		fmt.Printf("--output must be debug, json or jsonl, not '%s'\n", options.Output)
+		exit(1)
+	}
+	nullOnNotFound = options.OutputNullOnError && jsonOutput() && actions[options.Action].read
+	stripNullFields = options.StripNullFields
+	if options.JQ != "" {
+		if !jsonOutput() {
+			fmt.Println("--jq only works with --output json or jsonl")
+			exit(1)
+		}
+		jqQuery, err = gojq.Parse(options.JQ)
+		if err != nil {
+			fmt.Printf("--jq program %q doesn't parse: %s\n", options.JQ, err)
+			exit(1)
+		}
+	}
+	if options.JSONArray {
+		if outputFormat != "jsonl" {
+			fmt.Println("--json-array only works with --output jsonl")
+			exit(1)
+		}
+		jsonArray = true
+		atExit(closeJSONArray)
+	}
+	if options.Timezone != "" {
+		loc, err := time.LoadLocation(options.Timezone)
+		checkError(err)
+		outputLocation = loc
+	}
+
+	if options.BackoffBase < 0 || options.BackoffCap < 0 {
+		fmt.Println("--backoff-base and --backoff-cap can't be negative")
+		exit(1)
+	}
+	if options.BackoffCap < options.BackoffBase {
+		fmt.Printf("--backoff-cap %s is less than --backoff-base %s\n", options.BackoffCap, options.BackoffBase)
+		exit(1)
+	}
+
+	if options.Watch || options.Action == "Exporter" {
+		options.Interval = clampInterval("--interval", options.Interval, options)
+	}
+
+	// the web connection builds its own client on the default transport, so
+	// wrap that as well as the clients we hand to Lightpads
+	http.DefaultTransport = wrapTransport(http.DefaultTransport, options)
+
+	if options.Version {
+		printVersion()
+		os.Exit(0)
+	}
 
 	if options.ListActions {
 		fmt.Printf(`Available actions:
@@ -45,222 +256,686 @@ func main() {
 Web:
   * GetHouses               - get a list of all House IDs
   * GetHouse --id <id>     - get the description of a House
-  * GetScenes               - get a list of all Scene IDs
+  * GetLocation --id <id>  - get the location and time zone of a House
+  * GetHouseTree [--id <id>] - get a House (or all Houses) with its Rooms, Loads and Lightpads as one JSON document
+                             (--include-scenes to add its Scene IDs, and --resolve to fetch each Scene too)
+                             (--tree-file <file> writes it to a file)
+  * GetScenes --id <id>    - get a list of all Scene IDs in a House (--resolve to fetch each Scene's name too)
   * GetScene --id <id>     - get the description of a Scene
   * GetRoom --id <id>      - get the description of a Room
   * GetLoad --id <id>     - get the description of a Load
   * GetLightpad --id <id> - get the description of a Lightpad
+  * GetGestures --id <id> - get the number of custom gestures on a Lightpad
+  * GetLightpadConfig --id <id> - get the current config of a Lightpad
+  * DiffLightpadConfig --id <id> --conf <string> - show which fields of a Lightpad's config --conf would change
+  * IsProvisioned --id <id> - print whether a Lightpad is provisioned; exits 1 if not, except with --watch
+  * CacheStatus             - show how old the cached topology is and what's in it, and how many HATs are cached
+                              (GetHouseTree and --name use the cache for --cache-ttl; --refresh fetches afresh)
+  * Serve                   - serve a REST API on --listen for controlling every load, finding pads by heartbeat:
+                              GET /loads, POST /loads/<llid>/level with {"level": <0-255>}, GET /events (SSE)
+                              (--listen defaults to 127.0.0.1:9743; anyone who can reach it can set levels)
 
-Lightpad - all require --lpip, --port, and --hat:
+Lightpad - all require --lpip, --port, and --hat, and never log in to the web API (see --no-auth):
+  (or pass --lightpad-id instead of --lpip and --port to find the pad by its heartbeat;
+   its address is then cached for --discovery-ttl, and --refresh-discovery listens afresh)
+  (the Set actions take --dry-run to print the request they would send instead of sending it)
   * GetLoadMetrics                     - Get metrics about current power draw
+                                         (--value-only prints just the watts, or the --value-field level)
   * SetLevel --level <int>             - Set the dim level range 0 (off) to 255 (on)
+                                         (use --level-step <int> to snap to the pad's supported steps)
   * SetLightpadConfig --conf <string>  - Upload a new Lightpad config to the pad
   * SetLoadConfig  --conf <string>     - Upload a new Load config to the pad
-  * SetLoadGlow  --conf <string>       - Turn on the glow ring manually
-  * Subscribe  --conf <string>         - Listen for state changes from the Lightpad
+                                         (either Set*Config can use --conf-watch <file> to re-apply on save)
+  * SetLoadGlow --id <llid> --color <color>
+                                       - Turn on the glow ring manually (--intensity, --timeout; or as --conf JSON)
+  * Subscribe                          - Listen for state changes from the Lightpad
+                                         (--output jsonl prints each event as a line of JSON; --json-array makes them one array)
+                                         (--max-reconnects <n> to reconnect with backoff if the pad drops the connection)
+                                         (--lpip <ip>,<ip>... or --all to merge the events of several pads)
+                                         (--keepalive <duration> --id <llid> to detect a dead pad)
+                                         (--power-glow --id <llid> to turn the glow ring into a power meter)
+                                         (--emit-initial-state --id <llid> to start with the current level and power)
+                                         (with several pads, --id <llid>,<llid>... names each pad's load, in --lpip order)
+                                         (--hats <json|@file> gives each pad its own HAT, by IP or Lightpad ID; --hat is the fallback)
+                                         (--max-concurrent-subscribes <n> to hold at most n pads' streams open at once)
+  * IdentifyLightpad --id <llid>       - Flash the glow ring so you can find the pad
+                                         (--blink-count, --blink-interval; --color or --conf sets the glow)
+  * MirrorLevel --source <llid/ip[:port]/hat> --target <llid/ip[:port]/hat>
+                                       - Apply every level change on the source load to the target (--invert, --debounce)
+                                         (the pads come from --source and --target instead of --lpip and --hat)
+  * GlowFor --id <llid> --color <color> --duration <duration>
+                                       - Light the glow ring, then clear it after --duration or on Ctrl-C
+  * Bridge --id <llid> --mqtt <url>   - Publish the pad's events to MQTT as <prefix>/<llid>/level, power and motion,
+                                         and set the level from <prefix>/<llid>/set_level (--mqtt-prefix, default plum)
+                                         (--ha-discovery to appear in Home Assistant as a light with a power sensor)
+  * Exporter --id <llid>              - Serve Prometheus metrics of the load's level, power and events on --listen
+                                         (default 127.0.0.1:9743), polling the pad every --interval
+
+Discovery - needs no flags:
+  * Discover                           - Listen for Lightpad heartbeats for --discover-for (default 10s)
+                                         and print each pad's IP, port and Lightpad ID
+
+With --test, web and Lightpad actions run against canned data instead of the network.
+
+Any Get action can be repeated every --interval with --watch.
+
+--house-id, --room-id, --load-id, --lightpad-id and --scene-id can be used in place of --id;
+add --validate-id to check the ID is of that type before running the action.
+Or give --name and the ID is looked up from the name, or a unique prefix or part of it.
+
+Every action is also a subcommand of web or pad, named in lower case with dashes,
+eg web get-house or pad set-level, which takes the same flags as --action does.
 
 Examples:
+  ./plumcliraw web get-house --email me@example.com --password 'friend' --id 8aae8c21-f60a-472d-a982-b89a7bb945e9
   ./plumcliraw -a GetHouses --email me@example.com --password 'friend'
   ./plumcliraw -a GetRoom --email me@example.com --password 'friend' --id dbb77fae-f027-4377-9f77-d46e0a4a7d49
   ./plumcliraw -a Subscribe --lpip 192.168.1.10 --port 8443 --hat 281babee-bb75-4a96-9de9-48c010089574
   ./plumcliraw -a SetLevel --lpip 192.168.1.10 --port 8443 --hat 281babee-bb75-4a96-9de9-48c010089574 --conf '{"level":0}' --id 8aae8c21-f60a-472d-a982-b89a7bb945e9
+  ./plumcliraw -a GetLoadMetrics --watch --interval 5s --lpip 192.168.1.10 --port 8443 --hat 281babee-bb75-4a96-9de9-48c010089574 --id 8aae8c21-f60a-472d-a982-b89a7bb945e9
   ./plumcliraw -a GetLoadMetrics --lpip 192.168.1.10 --port 8443 --hat 281babee-bb75-4a96-9de9-48c010089574 --id 8aae8c21-f60a-472d-a982-b89a7bb945e9
 `)
 		os.Exit(0)
 	}
 
+	// actions that need a HAT fall back on the one cached from an earlier
+	// web lookup, and failing that on fetching it if we have credentials
+	var fetchHAT bool
+	if actions[options.Action].needsFlag("hat") && options.HAT == "" && options.HATs == "" && !options.TestMode {
+		options.HAT, err = cachedHAT(options.HATHouse)
+		checkError(err)
+		fetchHAT = options.HAT == "" && options.Email != "" && !options.NoAuth
+	}
+
+	checkActionFlags(options)
+
+	// looking up a Lightpad ID in the web API confirms it and maps it to the
+	// pad's load, but without credentials the heartbeat alone will do
+	resolvePad := resolvesPadAddress(options, idKind)
+	// --sink tags readings with the load's room and house when there are
+	// credentials to look them up with
+	sinkLookup := options.Sink != "" && options.ID != "" && options.Email != "" && !options.NoAuth
+	spec := actions[options.Action]
+	needsWeb := !(spec.lightpad || spec.offline) || options.ValidateID || (resolvePad && options.Email != "" && !options.NoAuth) || fetchHAT || sinkLookup || options.Name != ""
+	if options.NoAuth && needsWeb {
+		fmt.Printf("--no-auth was given but %s needs Plum web credentials\n", options.Action)
+		exit(1)
+	}
+
 	var conn libplumraw.WebConnection
 	if options.TestMode {
 		conn = makeTestConn()
-	} else {
+	} else if needsWeb {
+		checkError(readPassword(&options))
 		conf := libplumraw.WebConnectionConfig{
 			Email:    options.Email,
 			Password: options.Password,
 		}
-		conn = libplumraw.NewWebConnection(conf)
+		conn = hatRecorder{libplumraw.NewWebConnection(conf)}
+	}
+	// lookups are remembered for the length of one run so that the same
+	// entity is only fetched once; --watch starts each pass afresh
+	memo := newMemoConn(conn)
+	if options.Name != "" {
+		idKind, err = resolveName(memo, &options)
+		checkError(err)
+		resolvePad = resolvesPadAddress(options, idKind)
+	}
+	if options.ValidateID && idKind != "" {
+		checkError(validateID(memo, idKind, options.ID))
+	}
+	if fetchHAT {
+		checkError(fetchHATs(memo))
+		options.HAT, err = cachedHAT(options.HATHouse)
+		checkError(err)
+		if options.HAT == "" {
+			fmt.Println("No House Access Token found for this account; pass --hat")
+			exit(1)
+		}
+	}
+	if resolvePad {
+		var web libplumraw.WebConnection
+		if conn != nil {
+			web = memo
+		}
+		checkError(resolvePadAddress(web, &options))
+	}
+	if options.HATs != "" && options.Action != "Subscribe" && actions[options.Action].needsFlag("hat") && !options.TestMode {
+		// the pad's address may only now be known
+		options.HAT = padHAT(options.LightpadIP, options.LightpadID, options.HAT)
+		if options.HAT == "" {
+			fmt.Printf("--hats has no HAT for Lightpad %s, and no --hat was given\n", options.LightpadIP)
+			exit(1)
+		}
+	}
+	if options.Sink != "" {
+		var web libplumraw.WebConnection
+		if conn != nil {
+			web = memo
+		}
+		metricSink, err = newLineSink(options.Sink, sinkTags(web, options.ID))
+		checkError(err)
+	}
+
+	if options.Watch {
+		if !actions[options.Action].read {
+			fmt.Printf("--watch only works with read actions, not '%s'\n", options.Action)
+			exit(1)
+		}
+		// only redraw for a person watching; anything else gets one result
+		// after another
+		redraw := !jsonOutput() && term.IsTerminal(int(os.Stdout.Fd()))
+		watching = true
+		for {
+			if redraw {
+				// clear the screen and redraw from the top, like watch(1)
+				fmt.Print("\033[H\033[2J")
+				fmt.Printf("Every %s: %s\t%s\n\n", options.Interval, options.Action, formatTime(time.Now()))
+			}
+			runWatched(memo, options)
+			time.Sleep(options.Interval)
+			memo = newMemoConn(conn)
+		}
+	}
+	runAction(memo, options)
+	recordSuccess()
+	exit(0)
+}
+
+// watching is set under --watch, where a failed run of the action is
+// reported and the next run tried rather than ending the command.
+var watching bool
+
+// watchAbort is what stop panics with under --watch, for runWatched to
+// recover from.
+type watchAbort struct{}
+
+// stop exits with code, or under --watch abandons just this run of the
+// action.
+func stop(code int) {
+	if watching {
+		panic(watchAbort{})
 	}
+	exit(code)
+}
+
+// runWatched is runAction for one run under --watch.
+func runWatched(conn libplumraw.WebConnection, options Options) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(watchAbort); !ok {
+				panic(r)
+			}
+		}
+	}()
+	runAction(conn, options)
+}
+
+func runAction(conn libplumraw.WebConnection, options Options) {
 	switch options.Action {
 	case "GetHouses":
 		houses, err := conn.GetHouses()
 		checkError(err)
-		spew.Dump(houses)
+		printResult(houses)
+		checkEmpty(len(houses), options.AllowEmptyResults)
 	case "GetHouse":
-		checkID("House ID", options.ID)
 		house, err := conn.GetHouse(options.ID)
 		checkError(err)
-		spew.Dump(house)
+		printResult(house)
+	case "GetLocation":
+		house, err := conn.GetHouse(options.ID)
+		checkError(err)
+		loc := newHouseLocation(house)
+		if jsonOutput() {
+			printResult(loc)
+			break
+		}
+		fmt.Print(loc)
+	case "GetHouseTree":
+		runGetHouseTree(conn, options)
 	case "GetScenes":
-		checkID("House ID", options.ID)
 		scenes, err := conn.GetScenes(options.ID)
 		checkError(err)
-		spew.Dump(scenes)
+		if options.Resolve {
+			resolved, err := resolveScenes(conn, scenes)
+			checkError(err)
+			printScenes(resolved)
+		} else {
+			printResult(scenes)
+		}
+		checkEmpty(len(scenes), options.AllowEmptyResults)
 	case "GetScene":
-		checkID("Scene ID", options.ID)
 		scene, err := conn.GetScene(options.ID)
 		checkError(err)
-		spew.Dump(scene)
+		printResult(scene)
 	case "GetRoom":
-		checkID("Room ID", options.ID)
 		room, err := conn.GetRoom(options.ID)
 		checkError(err)
-		spew.Dump(room)
+		printResult(room)
 	case "GetLoad":
-		checkID("Logical Load ID", options.ID)
 		load, err := conn.GetLogicalLoad(options.ID)
 		checkError(err)
-		spew.Dump(load)
+		printResult(load)
 	case "GetLightpad":
-		checkID("Lightpad ID", options.ID)
 		pad, err := conn.GetLightpad(options.ID)
 		checkError(err)
-		spew.Dump(pad)
-	case "GetLoadMetrics":
-		checkLightpadFlags(options.LightpadIP, options.Port, options.HAT)
-		ip := net.ParseIP(options.LightpadIP)
-		checkIP(ip)
-		lp := libplumraw.DefaultLightpad{
-			LLID: options.ID,
-			IP:   ip,
-			Port: options.Port,
-			HttpClient: &http.Client{Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			}},
-			HAT: options.HAT,
+		printResult(pad)
+	case "GetGestures":
+		pad, err := conn.GetLightpad(options.ID)
+		checkError(err)
+		// the web API only reports how many gestures are configured, not
+		// what they're mapped to
+		if jsonOutput() {
+			printResult(struct {
+				ID             string `json:"id"`
+				Name           string `json:"name"`
+				CustomGestures int    `json:"custom_gestures"`
+			}{pad.ID, pad.Name, pad.CustomGestures})
+			break
 		}
-		mets, err := lp.GetLogicalLoadMetrics()
+		fmt.Printf("Lightpad %s (%s) has %d custom gestures\n", pad.Name, pad.ID, pad.CustomGestures)
+	case "GetLightpadConfig":
+		pad, err := conn.GetLightpad(options.ID)
 		checkError(err)
-		spew.Dump(mets)
+		printResult(pad.Config)
+	case "DiffLightpadConfig":
+		runDiffLightpadConfig(conn, options)
+	case "IsProvisioned":
+		pad, err := conn.GetLightpad(options.ID)
+		checkError(err)
+		if jsonOutput() {
+			printResult(struct {
+				Provisioned bool `json:"provisioned"`
+			}{pad.IsProvisioned})
+		} else {
+			fmt.Println(pad.IsProvisioned)
+		}
+		if !pad.IsProvisioned && !options.Watch {
+			// keep watching for the pad to be provisioned
+			exit(1)
+		}
+	case "GetLoadMetrics":
+		lp := newLightpad(options, nil)
+		mets, err := lp.GetLogicalLoadMetrics()
+		checkPadError(err)
+		if metricSink != nil {
+			if err := metricSink.writeMetrics(mets, options.LightpadIP, time.Now()); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to write metrics to --sink: %s\n", err)
+			}
+		}
+		if options.ValueOnly {
+			switch options.ValueField {
+			case "power":
+				fmt.Println(mets.Power)
+			case "level":
+				fmt.Println(formatLevel(mets.Level))
+			default:
+				fmt.Printf("--value-field must be power or level, not '%s'\n", options.ValueField)
+				exit(1)
+			}
+			break
+		}
+		printMetrics(mets)
 	case "SetLevel":
-		checkLightpadFlags(options.LightpadIP, options.Port, options.HAT)
-		ip := net.ParseIP(options.LightpadIP)
-		checkIP(ip)
+		lp := newLightpad(options, nil)
 		conf := struct{ Level int }{}
-		err := json.Unmarshal([]byte(options.Conf), &conf)
+		err := unmarshalConf(options.Conf, &conf)
 		checkError(err)
-		lp := libplumraw.DefaultLightpad{
-			LLID: options.ID,
-			IP:   ip,
-			Port: options.Port,
-			HttpClient: &http.Client{Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			}},
-			HAT: options.HAT,
+		if conf.Level < 0 || conf.Level > 255 {
+			checkError(&confError{fmt.Errorf("--conf level must be from 0 to 255, not %d", conf.Level)})
+		}
+		if options.LevelStep > 0 {
+			conf.Level = snapLevel(conf.Level, options.LevelStep)
 		}
 		err = lp.SetLogicalLoadLevel(conf.Level)
-		checkError(err)
+		checkPadError(err)
 	case "SetLightpadConfig":
-		checkLightpadFlags(options.LightpadIP, options.Port, options.HAT)
-		ip := net.ParseIP(options.LightpadIP)
-		checkIP(ip)
-		conf := libplumraw.LightpadConfig{}
-		err := json.Unmarshal([]byte(options.Conf), &conf)
-		checkError(err)
-		fmt.Printf("unpacked %s, %+v\n", ip, conf)
-		buf, err := json.Marshal(conf)
-		fmt.Printf("and remarshaled: %s\n", string(buf))
-		lp := libplumraw.DefaultLightpad{
-			LLID:       options.ID,
-			IP:         ip,
-			Port:       options.Port,
-			HttpClient: &http.Client{},
-		}
-		err = lp.SetLightpadConfig(conf)
-		checkError(err)
+		lp := newLightpad(options, nil)
+		apply := func(confJSON string) error {
+			conf := libplumraw.LightpadConfig{}
+			err := unmarshalConf(confJSON, &conf)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("unpacked %s, %+v\n", options.LightpadIP, conf)
+			buf, err := json.Marshal(conf)
+			fmt.Printf("and remarshaled: %s\n", string(buf))
+			return lp.SetLightpadConfig(conf)
+		}
+		if options.ConfWatch != "" {
+			watchConf(options.ConfWatch, options.ApplyOnStart, apply)
+		}
+		checkPadError(apply(options.Conf))
 	case "SetLoadConfig":
-		checkLightpadFlags(options.LightpadIP, options.Port, options.HAT)
-		ip := net.ParseIP(options.LightpadIP)
-		checkIP(ip)
-		conf := libplumraw.LogicalLoadConfig{}
-		err := json.Unmarshal([]byte(options.Conf), &conf)
-		checkError(err)
-		fmt.Printf("unpacked %s, %+v\n", ip, conf)
-		buf, err := json.Marshal(conf)
-		fmt.Printf("and remarshaled: %s\n", string(buf))
-		lp := libplumraw.DefaultLightpad{
-			LLID: options.ID,
-			IP:   ip,
-			Port: options.Port,
-			HttpClient: &http.Client{Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			}},
-			HAT: options.HAT,
-		}
-		err = lp.SetLogicalLoadConfig(conf)
-		checkError(err)
+		lp := newLightpad(options, nil)
+		apply := func(confJSON string) error {
+			conf := libplumraw.LogicalLoadConfig{}
+			err := unmarshalConf(confJSON, &conf)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("unpacked %s, %+v\n", options.LightpadIP, conf)
+			buf, err := json.Marshal(conf)
+			fmt.Printf("and remarshaled: %s\n", string(buf))
+			return lp.SetLogicalLoadConfig(conf)
+		}
+		if options.ConfWatch != "" {
+			watchConf(options.ConfWatch, options.ApplyOnStart, apply)
+		}
+		checkPadError(apply(options.Conf))
 	case "SetLoadGlow":
-		checkLightpadFlags(options.LightpadIP, options.Port, options.HAT)
-		ip := net.ParseIP(options.LightpadIP)
-		checkIP(ip)
-		conf := libplumraw.ForceGlow{}
-		err := json.Unmarshal([]byte(options.Conf), &conf)
+		lp := newLightpad(options, nil)
+		glow, err := glowFromOptions(options, libplumraw.ForceGlow{}, true)
 		checkError(err)
-		fmt.Printf("unpacked %s, %+v\n", ip, conf)
+		err = lp.SetLogicalLoadGlow(glow)
+		checkPadError(err)
 	case "Subscribe":
-		checkLightpadFlags(options.LightpadIP, options.Port, options.HAT)
-		ip := net.ParseIP(options.LightpadIP)
-		checkIP(ip)
-		fmt.Printf("unpacked %s\n", ip)
-		lp := libplumraw.DefaultLightpad{
-			LLID: options.ID,
-			IP:   ip,
-			Port: options.Port,
-			HttpClient: &http.Client{Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			}},
-			HAT:          options.HAT,
-			StateChanges: make(chan libplumraw.Event, 0),
-		}
-		err := lp.Subscribe(context.Background())
+		runSubscribe(options)
+	case "IdentifyLightpad":
+		lp := newLightpad(options, nil)
+		glow, err := glowFromOptions(options, libplumraw.ForceGlow{Intensity: 100, White: 255}, false)
 		checkError(err)
-		for ev := range lp.StateChanges {
-			switch ev := ev.(type) {
-			case libplumraw.LPEDimmerChange:
-				fmt.Printf("heard a %s event with value %d\n", ev.Type, ev.Level)
-				// spew.Dump(ev.(libplumraw.LPEDimmerChange))
-			case libplumraw.LPEPower:
-				fmt.Printf("heard a %s event with value %d\n", ev.Type, ev.Watts)
-				// spew.Dump(ev.(libplumraw.LPEPower))
-			case libplumraw.LPEPIRSignal:
-				fmt.Printf("heard a %s event with value %d\n", ev.Type, ev.Signal)
-				// lp.SetLogicalLoadLevel(255) // turn the light on in response to motion
-				// spew.Dump(ev.(libplumraw.LPEPower))
-			case libplumraw.LPEUnknown:
-				fmt.Printf("heard an unknown event with message %s\n", ev.Message)
-				// spew.Dump(ev.(libplumraw.LPEPower))
-			}
+		glow.Timeout = int(options.BlinkInterval / time.Millisecond)
+		off := libplumraw.ForceGlow{LLID: options.ID}
+		for i := 0; i < options.BlinkCount; i++ {
+			err := lp.SetLogicalLoadGlow(glow)
+			checkPadError(err)
+			time.Sleep(options.BlinkInterval)
+			err = lp.SetLogicalLoadGlow(off)
+			checkPadError(err)
+			time.Sleep(options.BlinkInterval)
 		}
-
+	case "MirrorLevel":
+		runMirrorLevel(options)
+	case "GlowFor":
+		lp := newLightpad(options, nil)
+		glow, err := glowFromOptions(options, libplumraw.ForceGlow{}, true)
+		checkError(err)
+		// have the pad time the glow out too, in case we're killed outright
+		glow.Timeout = int(options.Duration / time.Millisecond)
+		err = lp.SetLogicalLoadGlow(glow)
+		checkPadError(err)
+		// the pad can't tell us what glow it had before, so just clear ours,
+		// whether --duration runs out or we're interrupted first
+		var clearOnce sync.Once
+		clearGlow := func() (err error) {
+			clearOnce.Do(func() {
+				err = lp.SetLogicalLoadGlow(libplumraw.ForceGlow{LLID: options.ID})
+			})
+			return err
+		}
+		atExit(func() {
+			if err := clearGlow(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to clear the glow: %s\n", err)
+			}
+		})
+		time.Sleep(options.Duration)
+		checkPadError(clearGlow())
+	case "Discover":
+		runDiscover(options)
+	case "Bridge":
+		runBridge(options)
+	case "Exporter":
+		runExporter(options)
+	case "Serve":
+		runServe(conn, options)
+	case "CacheStatus":
+		runCacheStatus(options)
 	default:
 		fmt.Printf("Action '%s' not recognized\n", options.Action)
+		exit(1)
 	}
 
 }
 
-func checkID(name string, flag string) {
-	if flag == "" {
-		fmt.Printf("%s must be specified with the --id flag\n", name)
-		os.Exit(1)
+// lightpad is the set of Lightpad operations the CLI uses. It is satisfied by
+// libplumraw.DefaultLightpad and, under --test, by testLightpad.
+type lightpad interface {
+	GetLogicalLoadMetrics() (libplumraw.LogicalLoadMetrics, error)
+	SetLogicalLoadLevel(level int) error
+	SetLightpadConfig(conf libplumraw.LightpadConfig) error
+	SetLogicalLoadConfig(conf libplumraw.LogicalLoadConfig) error
+	SetLogicalLoadGlow(glow libplumraw.ForceGlow) error
+	Subscribe(ctx context.Context) error
+}
+
+// newLightpad validates the Lightpad flags and returns the pad they describe.
+// Events from Subscribe are delivered on stateChanges.
+func newLightpad(options Options, stateChanges chan libplumraw.Event) lightpad {
+	if options.TestMode {
+		return makeTestLightpad(stateChanges)
+	}
+	ip := parseIP(options.LightpadIP)
+	return &libplumraw.DefaultLightpad{
+		LLID:         options.ID,
+		IP:           ip,
+		Port:         options.Port,
+		HttpClient:   padHTTPClient(options),
+		HAT:          options.HAT,
+		StateChanges: stateChanges,
 	}
 }
 
-func checkIP(ip net.IP) {
+// parseIP parses the --lpip address, exiting if it isn't one.
+func parseIP(lpip string) net.IP {
+	ip := net.ParseIP(lpip)
 	if ip == nil {
-		fmt.Printf("IP address failed to parse.\n", ip)
-		os.Exit(1)
+		fmt.Printf("IP address %q failed to parse.\n", lpip)
+		exit(1)
 	}
+	return ip
 }
 
-func checkLightpadFlags(lpip string, port int, hat string) {
-	if lpip == "" || port == 0 || hat == "" {
-		fmt.Println("Lightpad IP address, port number, and House Access Token must all be specified.")
-		os.Exit(1)
+// keepalive polls the Lightpad every interval so idle connections (and any NAT
+// mapping in between) stay open. If the pad stops answering, the subscription
+// is dropped so that listen resubscribes, rather than sitting on an event
+// stream that has silently died.
+func keepalive(pad string, lp lightpad, interval time.Duration, sub *subscription) {
+	for range time.Tick(interval) {
+		if _, err := lp.GetLogicalLoadMetrics(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: keepalive to Lightpad %s failed, resubscribing: %s\n", pad, err)
+			sub.drop()
+		}
+	}
+}
+
+// powerGlow maps watts onto a glow that fades from green at 0W to red at
+// wattsMax and above.
+func powerGlow(llid string, watts, wattsMax int) libplumraw.ForceGlow {
+	frac := float64(watts) / float64(wattsMax)
+	if frac < 0 {
+		frac = 0
+	}
+	if frac > 1 {
+		frac = 1
+	}
+	return libplumraw.ForceGlow{
+		LLID:      llid,
+		Intensity: 100,
+		Red:       int(255 * frac),
+		Green:     int(255 * (1 - frac)),
+	}
+}
+
+// outputLocation is the time zone timestamps are printed in.
+var outputLocation = time.Local
+
+func formatTime(t time.Time) string {
+	return t.In(outputLocation).Format(time.RFC3339)
+}
+
+// levelFormat is how levels are printed: "raw" (0-255), "percent" or "both".
+var levelFormat = "raw"
+
+func formatLevel(level int) string {
+	percent := fmt.Sprintf("%d%%", (level*100+127)/255)
+	switch levelFormat {
+	case "percent":
+		return percent
+	case "both":
+		return fmt.Sprintf("%d (%s)", level, percent)
+	}
+	return fmt.Sprintf("%d", level)
+}
+
+// printMetrics prints load metrics with printResult, followed by the level in
+// the --format-level format unless that's raw or they're printed as JSON,
+// which keeps the raw level for scripts. The metrics themselves are printed
+// as libplumraw returns them, so no field it adds is ever left out.
+func printMetrics(mets libplumraw.LogicalLoadMetrics) {
+	printResult(mets)
+	if !jsonOutput() && levelFormat != "raw" {
+		fmt.Printf("Level: %s\n", formatLevel(mets.Level))
+	}
+}
+
+// clampInterval raises a polling interval shorter than --min-interval to it,
+// so a typo like --interval 10ms doesn't hammer the pad or Plum's cloud.
+// --allow-fast-polling leaves it alone.
+func clampInterval(name string, interval time.Duration, options Options) time.Duration {
+	if interval >= options.MinInterval || options.AllowFastPolling {
+		return interval
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s %s is shorter than --min-interval; polling every %s instead (--allow-fast-polling to override)\n",
+		name, interval, options.MinInterval)
+	return options.MinInterval
+}
+
+// snapLevel rounds level to the nearest multiple of step, or to 255 if that's
+// nearer, warning when the requested level wasn't already a valid step.
+func snapLevel(level, step int) int {
+	snapped := (level + step/2) / step * step
+	diff := level - snapped
+	if diff < 0 {
+		diff = -diff
+	}
+	// full on stays reachable even when 255 isn't a multiple of step
+	if snapped > 255 || 255-level < diff {
+		snapped = 255
+	}
+	if snapped != level {
+		fmt.Fprintf(os.Stderr, "Warning: level %s is not a multiple of %d; using %s instead\n",
+			formatLevel(level), step, formatLevel(snapped))
+	}
+	return snapped
+}
+
+// readConf returns the --conf JSON: read from the named file if conf is
+// @<file>, from stdin if it's -, and otherwise conf itself.
+func readConf(conf string) (string, error) {
+	var buf []byte
+	var err error
+	switch {
+	case conf == "-":
+		buf, err = ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(conf, "@"):
+		buf, err = ioutil.ReadFile(strings.TrimPrefix(conf, "@"))
+	default:
+		return conf, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read --conf %s: %s", conf, err)
+	}
+	return string(buf), nil
+}
+
+// unmarshalConf decodes the --conf JSON into v. A "base64:" prefix marks the
+// JSON as base64 encoded, which saves quoting it through other systems.
+func unmarshalConf(conf string, v interface{}) error {
+	buf := []byte(conf)
+	if strings.HasPrefix(conf, "base64:") {
+		var err error
+		buf, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(conf, "base64:"))
+		if err != nil {
+			return &confError{fmt.Errorf("failed to decode base64 --conf: %s", err)}
+		}
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		var offset int64 = -1
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		} else if errors.As(err, &typeErr) {
+			offset = typeErr.Offset
+		}
+		if offset >= 0 {
+			line, col := lineColumn(buf, offset)
+			err = fmt.Errorf("--conf line %d, column %d: %w", line, col, err)
+		}
+		return &confError{err}
+	}
+	return nil
+}
+
+// lineColumn returns the 1-based line and column in buf of the byte just
+// before offset, which is where encoding/json reports errors.
+func lineColumn(buf []byte, offset int64) (int, int) {
+	if offset > int64(len(buf)) {
+		offset = int64(len(buf))
+	}
+	before := buf[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n') - 1
+	return line, col
+}
+
+// exitEmpty is the exit code used when a list action finds nothing, so that
+// scripts can tell "found nothing" apart from success and from errors.
+const exitEmpty = 5
+
+func checkEmpty(count int, allowEmpty bool) {
+	if count == 0 && !allowEmpty {
+		// nothing now may be something on the next run
+		if !watching {
+			exit(exitEmpty)
+		}
 	}
 }
 
 func checkError(err error) {
+	failOnError(err, 1)
+}
+
+// checkPadError is checkError for errors from a Lightpad. Failing to reach
+// the pad at all exits with the --exit-code-on-unreachable code so monitoring
+// can tell "pad down" apart from other failures.
+func checkPadError(err error) {
+	if err != nil && isUnreachable(err) {
+		failOnError(err, unreachableExitCode)
+	}
+	checkError(err)
+}
+
+func failOnError(err error, code int) {
+	if err != nil && nullOnNotFound && httpStatus(err) == http.StatusNotFound {
+		// a missing entity is an answer, not a failure; under --watch
+		// the run's outcome is only recorded when it ends
+		fmt.Println("null")
+		if !watching {
+			recordSuccess()
+		}
+		stop(0)
+	}
 	if err != nil {
+		recordFailure(err)
+		// bad --conf and unparseable responses say what's wrong themselves,
+		// and their "invalid character" mustn't pass for a 400
+		var confErr *confError
+		if prettyErrors && !errors.As(err, &confErr) && !isParseError(err) {
+			if msg, hint, ok := explainError(err); ok {
+				fmt.Printf("Error: %s\nHint: %s\n", msg, hint)
+				if verbose {
+					fmt.Printf("Raw error: %s\n", err)
+				}
+				stop(code)
+			}
+		}
 		fmt.Printf("Error: %s\n", err)
-		os.Exit(1)
+		if isParseError(err) {
+			fmt.Printf("Hint: this may be a Plum API change that libplumraw %s doesn't handle yet\n", libplumrawVersion())
+		}
+		stop(code)
 	}
 }
 
@@ -283,12 +958,9 @@ func makeTestConn() *libplumraw.TestWebConnection {
 	conn := &libplumraw.TestWebConnection{
 		Houses: libplumraw.Houses{"aaa", "bbb"},
 		House: libplumraw.House{
-			ID:      "ccc",
-			RoomIDs: []string{"ddd", "eee"},
-			LatLong: struct {
-				Latitude  float64 `json:"latitude_degrees_north,omitempty"` // decimal degrees North
-				Longitude float64 `json:"longitude_degrees_west,omitempty"` // decimal degrees West
-			}{123.456, 789.012},
+			ID:          "ccc",
+			RoomIDs:     []string{"ddd", "eee"},
+			LatLong:     LatLong{123.456, 789.012},
 			AccessToken: "fff",
 			Name:        "ggg",
 			TimeZone:    234,
@@ -316,3 +988,24 @@ func makeTestConn() *libplumraw.TestWebConnection {
 	}
 	return conn
 }
+
+func makeTestLightpad(stateChanges chan libplumraw.Event) *testLightpad {
+	lp := &testLightpad{
+		Metrics: libplumraw.LogicalLoadMetrics{
+			LLID:  "mmm",
+			Level: 128,
+			Power: 42,
+			LightpadMetrics: []libplumraw.LightpadMetric{
+				{LPID: "rrr", Power: 42},
+			},
+		},
+		Events: []libplumraw.Event{
+			libplumraw.LPEDimmerChange{Type: "dimmerchange", Level: 128},
+			libplumraw.LPEPower{Type: "power", Watts: 42},
+			libplumraw.LPEPIRSignal{Type: "pirSignal", Signal: 7},
+			libplumraw.LPEUnknown{Type: "unknown", Message: `{"type":"uuu"}`},
+		},
+		StateChanges: stateChanges,
+	}
+	return lp
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf.json")
+	if err := ioutil.WriteFile(path, []byte(`{"level": 10}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := readConf("@" + path)
+	if err != nil || conf != `{"level": 10}` {
+		t.Errorf("readConf(@file) = %q, %v", conf, err)
+	}
+	conf, err = readConf(`{"level": 20}`)
+	if err != nil || conf != `{"level": 20}` {
+		t.Errorf("readConf(json) = %q, %v", conf, err)
+	}
+	if _, err := readConf("@" + path + ".missing"); err == nil {
+		t.Error("readConf of a missing @file succeeded")
+	}
+}
+
+func TestUnmarshalConfPosition(t *testing.T) {
+	tests := []struct {
+		conf, where string
+	}{
+		{"{\n  \"level\": x\n}", "line 2, column 12"},
+		{"{\n  \"level\": \"high\"\n}", "line 2, column 17"},
+		{`{"level": 10,}`, "line 1, column 14"},
+	}
+	// columns count from 1, and point at the offending character or the end
+	// of a value of the wrong type
+	for _, tt := range tests {
+		var conf struct{ Level int }
+		err := unmarshalConf(tt.conf, &conf)
+		if err == nil || !strings.Contains(err.Error(), "--conf "+tt.where+":") {
+			t.Errorf("unmarshalConf(%q) error = %v, want it at %s", tt.conf, err, tt.where)
+		}
+	}
+}
+
+func TestLineColumn(t *testing.T) {
+	buf := []byte("ab\ncd\n")
+	for offset, want := range [][2]int{{1, 0}, {1, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 2}, {3, 0}, {3, 0}} {
+		line, col := lineColumn(buf, int64(offset))
+		if line != want[0] || col != want[1] {
+			t.Errorf("lineColumn(%d) = %d:%d, want %d:%d", offset, line, col, want[0], want[1])
+		}
+	}
+}
+
+func TestClampInterval(t *testing.T) {
+	options := Options{MinInterval: time.Second}
+	if got := clampInterval("--interval", 10*time.Millisecond, options); got != time.Second {
+		t.Errorf("clampInterval(10ms) = %s, want 1s", got)
+	}
+	if got := clampInterval("--interval", 5*time.Second, options); got != 5*time.Second {
+		t.Errorf("clampInterval(5s) = %s, want 5s", got)
+	}
+	options.AllowFastPolling = true
+	if got := clampInterval("--interval", 10*time.Millisecond, options); got != 10*time.Millisecond {
+		t.Errorf("clampInterval(10ms) with --allow-fast-polling = %s", got)
+	}
+}
+
+func TestSnapLevel(t *testing.T) {
+	tests := []struct{ level, step, want int }{
+		{100, 10, 100},
+		{104, 10, 100},
+		{105, 10, 110},
+		{0, 64, 0},
+		// 256 isn't a level, so the top step is 255 rather than 256
+		{250, 64, 255},
+		{255, 64, 255},
+		// nearer 192 than 255
+		{200, 64, 192},
+	}
+	for _, tt := range tests {
+		if got := snapLevel(tt.level, tt.step); got != tt.want {
+			t.Errorf("snapLevel(%d, %d) = %d, want %d", tt.level, tt.step, got, tt.want)
+		}
+	}
+}
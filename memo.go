@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// memoConn wraps a WebConnection and remembers each successful lookup, so
+// repeated requests for the same ID during a single run only hit the API
+// once. It should not outlive the run it was made for, or results go stale.
+type memoConn struct {
+	conn libplumraw.WebConnection
+
+	mu           sync.Mutex
+	houses       libplumraw.Houses
+	housesDone   bool
+	house        map[string]libplumraw.House
+	scenes       map[string]libplumraw.Scenes
+	scene        map[string]libplumraw.Scene
+	room         map[string]libplumraw.Room
+	logicalLoad  map[string]libplumraw.LogicalLoad
+	lightpadSpec map[string]libplumraw.LightpadSpec
+}
+
+func newMemoConn(conn libplumraw.WebConnection) *memoConn {
+	return &memoConn{
+		conn:         conn,
+		house:        make(map[string]libplumraw.House),
+		scenes:       make(map[string]libplumraw.Scenes),
+		scene:        make(map[string]libplumraw.Scene),
+		room:         make(map[string]libplumraw.Room),
+		logicalLoad:  make(map[string]libplumraw.LogicalLoad),
+		lightpadSpec: make(map[string]libplumraw.LightpadSpec),
+	}
+}
+
+func (m *memoConn) GetHouses() (libplumraw.Houses, error) {
+	m.mu.Lock()
+	if m.housesDone {
+		defer m.mu.Unlock()
+		return m.houses, nil
+	}
+	m.mu.Unlock()
+	houses, err := m.conn.GetHouses()
+	if err != nil {
+		return houses, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.houses, m.housesDone = houses, true
+	return houses, nil
+}
+
+func (m *memoConn) GetHouse(hid string) (libplumraw.House, error) {
+	m.mu.Lock()
+	house, ok := m.house[hid]
+	m.mu.Unlock()
+	if ok {
+		return house, nil
+	}
+	house, err := m.conn.GetHouse(hid)
+	if err != nil {
+		return house, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.house[hid] = house
+	return house, nil
+}
+
+func (m *memoConn) GetScenes(hid string) (libplumraw.Scenes, error) {
+	m.mu.Lock()
+	scenes, ok := m.scenes[hid]
+	m.mu.Unlock()
+	if ok {
+		return scenes, nil
+	}
+	scenes, err := m.conn.GetScenes(hid)
+	if err != nil {
+		return scenes, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scenes[hid] = scenes
+	return scenes, nil
+}
+
+func (m *memoConn) GetScene(sid string) (libplumraw.Scene, error) {
+	m.mu.Lock()
+	scene, ok := m.scene[sid]
+	m.mu.Unlock()
+	if ok {
+		return scene, nil
+	}
+	scene, err := m.conn.GetScene(sid)
+	if err != nil {
+		return scene, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scene[sid] = scene
+	return scene, nil
+}
+
+func (m *memoConn) GetRoom(rid string) (libplumraw.Room, error) {
+	m.mu.Lock()
+	room, ok := m.room[rid]
+	m.mu.Unlock()
+	if ok {
+		return room, nil
+	}
+	room, err := m.conn.GetRoom(rid)
+	if err != nil {
+		return room, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.room[rid] = room
+	return room, nil
+}
+
+func (m *memoConn) GetLogicalLoad(llid string) (libplumraw.LogicalLoad, error) {
+	m.mu.Lock()
+	load, ok := m.logicalLoad[llid]
+	m.mu.Unlock()
+	if ok {
+		return load, nil
+	}
+	load, err := m.conn.GetLogicalLoad(llid)
+	if err != nil {
+		return load, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logicalLoad[llid] = load
+	return load, nil
+}
+
+func (m *memoConn) GetLightpad(lpid string) (libplumraw.LightpadSpec, error) {
+	m.mu.Lock()
+	pad, ok := m.lightpadSpec[lpid]
+	m.mu.Unlock()
+	if ok {
+		return pad, nil
+	}
+	pad, err := m.conn.GetLightpad(lpid)
+	if err != nil {
+		return pad, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lightpadSpec[lpid] = pad
+	return pad, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// padOptions returns a copy of options pointed at the Lightpad described by
+// spec, which is of the form "llid/ip[:port]/hat". The port defaults to
+// --port.
+func padOptions(options Options, spec string) (Options, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 3 {
+		return options, fmt.Errorf("%q should be of the form llid/ip[:port]/hat", spec)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return options, fmt.Errorf("%q should be of the form llid/ip[:port]/hat", spec)
+		}
+	}
+	options.ID, options.LightpadIP, options.HAT = parts[0], parts[1], parts[2]
+	if host, port, err := net.SplitHostPort(parts[1]); err == nil {
+		options.LightpadIP = host
+		options.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return options, fmt.Errorf("bad port in %q: %s", spec, err)
+		}
+	}
+	return options, nil
+}
+
+// runMirrorLevel follows dimmer changes on the --source pad and applies the
+// same level (or its inverse) to the --target pad. Changes are debounced so a
+// slide on the source is only sent to the target once it settles, and a level
+// the target already has isn't sent again, so two pads mirroring each other
+// don't feed back.
+func runMirrorLevel(options Options) {
+	srcOptions, err := padOptions(options, options.Source)
+	checkError(err)
+	dstOptions, err := padOptions(options, options.Target)
+	checkError(err)
+
+	stateChanges := make(chan libplumraw.Event, 0)
+	src := newLightpad(srcOptions, stateChanges)
+	dst := newLightpad(dstOptions, nil)
+	err = src.Subscribe(context.Background())
+	checkPadError(err)
+
+	last := -1
+	pending := -1
+	debounce := time.NewTimer(options.Debounce)
+	debounce.Stop()
+	for {
+		select {
+		case ev, ok := <-stateChanges:
+			if !ok {
+				return
+			}
+			dimmer, ok := ev.(libplumraw.LPEDimmerChange)
+			if !ok {
+				continue
+			}
+			pending = dimmer.Level
+			if options.Invert {
+				pending = 255 - dimmer.Level
+			}
+			debounce.Reset(options.Debounce)
+		case <-debounce.C:
+			if pending == last {
+				continue
+			}
+			fmt.Printf("%s mirroring level %s to %s\n", formatTime(time.Now()), formatLevel(pending), dstOptions.ID)
+			if err := dst.SetLogicalLoadLevel(pending); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to set mirrored level: %s\n", err)
+				continue
+			}
+			last = pending
+		}
+	}
+}
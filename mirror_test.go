@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestPadOptions(t *testing.T) {
+	base := Options{ID: "other-llid", LightpadIP: "10.0.0.9", Port: 8443, HAT: "other-hat"}
+
+	t.Run("default port", func(t *testing.T) {
+		got, err := padOptions(base, "llid/10.0.0.1/hat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != "llid" || got.LightpadIP != "10.0.0.1" || got.Port != 8443 || got.HAT != "hat" {
+			t.Errorf("got %s at %s:%d with %s", got.ID, got.LightpadIP, got.Port, got.HAT)
+		}
+	})
+
+	t.Run("own port", func(t *testing.T) {
+		got, err := padOptions(base, "llid/[fe80::1]:9000/hat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.LightpadIP != "fe80::1" || got.Port != 9000 {
+			t.Errorf("got %s:%d, want fe80::1:9000", got.LightpadIP, got.Port)
+		}
+		if base.Port != 8443 {
+			t.Error("padOptions changed the options it was given")
+		}
+	})
+
+	for _, spec := range []string{"llid/10.0.0.1", "llid/10.0.0.1/hat/more", "llid//hat", "llid/10.0.0.1:port/hat", ""} {
+		if _, err := padOptions(base, spec); err == nil {
+			t.Errorf("padOptions(%q) succeeded, want an error", spec)
+		}
+	}
+}
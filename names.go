@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// idKinds maps what an action's --id names to the kind of entity --name
+// looks up in its place.
+var idKinds = map[string]string{
+	"House ID":        "house",
+	"Room ID":         "room",
+	"Logical Load ID": "load",
+	"Lightpad ID":     "lightpad",
+	"Scene ID":        "scene",
+}
+
+// namedEntity is something --name can match.
+type namedEntity struct {
+	name string
+	id   string
+	// lpids are a load's Lightpads, used to find one to talk to
+	lpids []string
+}
+
+// namedEntities returns every entity of the given kind in every house.
+func namedEntities(conn libplumraw.WebConnection, options Options, kind string) ([]namedEntity, error) {
+	var entities []namedEntity
+	if kind == "scene" {
+		hids, err := conn.GetHouses()
+		if err != nil {
+			return nil, err
+		}
+		for _, hid := range hids {
+			sids, err := conn.GetScenes(hid)
+			if err != nil {
+				return nil, err
+			}
+			scenes, err := resolveScenes(conn, sids)
+			if err != nil {
+				return nil, err
+			}
+			for _, scene := range scenes {
+				entities = append(entities, namedEntity{name: scene.Name, id: scene.ID})
+			}
+		}
+		return entities, nil
+	}
+	trees, err := houseTrees(conn, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, house := range trees {
+		if kind == "house" {
+			entities = append(entities, namedEntity{name: house.Name, id: house.ID})
+		}
+		for _, room := range house.Rooms {
+			if kind == "room" {
+				entities = append(entities, namedEntity{name: room.Name, id: room.ID})
+			}
+			for _, load := range room.Loads {
+				if kind == "load" {
+					entities = append(entities, namedEntity{name: load.Name, id: load.ID, lpids: load.LPIDs})
+				}
+				for _, pad := range load.Lightpads {
+					if kind == "lightpad" {
+						entities = append(entities, namedEntity{name: pad.Name, id: pad.ID})
+					}
+				}
+			}
+		}
+	}
+	return entities, nil
+}
+
+// matchName finds the entity called name, ignoring case. An exact match
+// wins; failing that, a name that starts with it, and failing that, one that
+// contains it. If more than one entity matches equally well, the error lists
+// them all.
+func matchName(entities []namedEntity, kind, name string) (namedEntity, error) {
+	want := strings.ToLower(name)
+	matchers := []func(string) bool{
+		func(n string) bool { return n == want },
+		func(n string) bool { return strings.HasPrefix(n, want) },
+		func(n string) bool { return strings.Contains(n, want) },
+	}
+	for _, match := range matchers {
+		var found []namedEntity
+		for _, e := range entities {
+			if match(strings.ToLower(e.name)) {
+				found = append(found, e)
+			}
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		}
+		if len(found) > 1 {
+			candidates := make([]string, len(found))
+			for i, e := range found {
+				candidates[i] = fmt.Sprintf("%q (%s)", e.name, e.id)
+			}
+			sort.Strings(candidates)
+			return namedEntity{}, fmt.Errorf("--name %q matches several %ss: %s", name, kind, strings.Join(candidates, ", "))
+		}
+	}
+	return namedEntity{}, fmt.Errorf("no %s is called %q", kind, name)
+}
+
+// nameKind returns the kind of entity --name names for the action, or "" if
+// the action takes no ID. Lightpad actions without an --id of their own act
+// on a load.
+func nameKind(options Options) string {
+	spec := actions[options.Action]
+	if kind, ok := idKinds[spec.idName]; ok {
+		return kind
+	}
+	if spec.lightpad {
+		return "load"
+	}
+	return ""
+}
+
+// resolveName sets --id from --name and returns the kind of entity the ID
+// is. For a Lightpad action without --lpip the ID is that of one of the
+// load's Lightpads instead, so that the pad's address can be found from it.
+func resolveName(conn libplumraw.WebConnection, options *Options) (string, error) {
+	kind := nameKind(*options)
+	if kind == "" {
+		return "", fmt.Errorf("%s doesn't take an ID, so --name can't be used with it", options.Action)
+	}
+	if options.ID != "" {
+		return "", fmt.Errorf("--name can't be used with --id or its aliases")
+	}
+	entities, err := namedEntities(conn, *options, kind)
+	if err != nil {
+		return "", err
+	}
+	e, err := matchName(entities, kind, options.Name)
+	if err != nil {
+		return "", err
+	}
+	options.ID = e.id
+	if actions[options.Action].needsFlag("lpip") && options.LightpadIP == "" && !options.TestMode {
+		if len(e.lpids) == 0 {
+			return "", fmt.Errorf("load %q has no Lightpads", e.name)
+		}
+		options.ID = e.lpids[0]
+		return "lightpad", nil
+	}
+	return kind, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var testRooms = []namedEntity{
+	{name: "Kitchen", id: "k"},
+	{name: "Kitchen Island", id: "ki"},
+	{name: "Living Room", id: "lr"},
+	{name: "Dining Room", id: "dr"},
+}
+
+func TestMatchName(t *testing.T) {
+	matches := map[string]string{
+		// an exact match wins over Kitchen Island starting with it
+		"kitchen":        "k",
+		"KITCHEN ISLAND": "ki",
+		"liv":            "lr",
+		// failing a prefix, any name containing it
+		"island": "ki",
+	}
+	for name, want := range matches {
+		e, err := matchName(testRooms, "room", name)
+		if err != nil {
+			t.Errorf("matchName(%q): %v", name, err)
+		} else if e.id != want {
+			t.Errorf("matchName(%q) = %s, want %s", name, e.id, want)
+		}
+	}
+
+	_, err := matchName(testRooms, "room", "room")
+	if err == nil || !strings.Contains(err.Error(), `"Dining Room" (dr), "Living Room" (lr)`) {
+		t.Errorf("ambiguous match error = %v, want both rooms listed", err)
+	}
+	if _, err := matchName(testRooms, "room", "garage"); err == nil {
+		t.Error("matchName found a garage")
+	}
+}
+
+func TestNameKind(t *testing.T) {
+	kinds := map[string]string{
+		"GetRoom":      "room",
+		"GetHouseTree": "house",
+		"SetLevel":     "load",
+		"Subscribe":    "load",
+		"GetHouses":    "",
+	}
+	for action, want := range kinds {
+		if got := nameKind(Options{Action: action}); got != want {
+			t.Errorf("nameKind(%s) = %q, want %q", action, got, want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/itchyny/gojq"
+)
+
+// outputFormat is how action results are printed, set from --output. debug
+// dumps the Go value with spew; json marshals it so it can be piped into jq.
+// jsonl is json on a single line, and has Subscribe print one line per event.
+var outputFormat = "debug"
+
+// jsonArray has the lines of --output jsonl printed as the elements of one
+// JSON array instead, set from --json-array. jsonArrayState tracks whether the
+// array has been opened and closed.
+var (
+	jsonArray      bool
+	jsonArrayMu    sync.Mutex
+	jsonArrayState int
+)
+
+const (
+	jsonArrayUnopened = iota
+	jsonArrayOpen
+	jsonArrayClosed
+)
+
+// stripNullFields has JSON results leave out null, empty and zero fields; set
+// from --strip-null-fields.
+var stripNullFields bool
+
+// jqQuery is the --jq program JSON results are run through, if any.
+var jqQuery *gojq.Query
+
+// printResult prints the result of an action in the --output format.
+func printResult(v interface{}) {
+	if outputFormat == "debug" {
+		spew.Dump(v)
+		return
+	}
+	var err error
+	if stripNullFields || jqQuery != nil {
+		v, err = genericJSON(v)
+		checkError(err)
+	}
+	if stripNullFields {
+		v = stripEmpty(v)
+	}
+	if jqQuery == nil {
+		printJSON(v)
+		return
+	}
+	results, err := runJQ(v)
+	checkError(err)
+	for _, result := range results {
+		printJSON(result)
+	}
+}
+
+// printJSON prints v as --output json or jsonl.
+func printJSON(v interface{}) {
+	var buf []byte
+	var err error
+	if outputFormat == "jsonl" {
+		buf, err = json.Marshal(v)
+	} else {
+		buf, err = json.MarshalIndent(v, "", "  ")
+	}
+	checkError(err)
+	if outputFormat == "jsonl" {
+		printLine(buf)
+		return
+	}
+	fmt.Println(string(buf))
+}
+
+// runJQ returns every output of the --jq program run over v, a value from
+// genericJSON. Nothing is returned if the program fails part way.
+func runJQ(v interface{}) ([]interface{}, error) {
+	var results []interface{}
+	iter := jqQuery.Run(v)
+	for {
+		result, ok := iter.Next()
+		if !ok {
+			return results, nil
+		}
+		if err, ok := result.(error); ok {
+			return nil, fmt.Errorf("--jq program failed: %s", err)
+		}
+		results = append(results, result)
+	}
+}
+
+// printLine prints one line of --output jsonl, or under --json-array the next
+// element of the array.
+func printLine(buf []byte) {
+	if !jsonArray {
+		fmt.Println(string(buf))
+		return
+	}
+	jsonArrayMu.Lock()
+	defer jsonArrayMu.Unlock()
+	switch jsonArrayState {
+	case jsonArrayUnopened:
+		fmt.Print("[\n")
+		jsonArrayState = jsonArrayOpen
+	case jsonArrayOpen:
+		fmt.Print(",\n")
+	case jsonArrayClosed:
+		// the run is exiting and the document is already complete
+		return
+	}
+	fmt.Print(string(buf))
+}
+
+// closeJSONArray ends the --json-array array. It runs as an exit hook, so a
+// stream stopped with Ctrl-C still leaves a valid JSON document.
+func closeJSONArray() {
+	jsonArrayMu.Lock()
+	defer jsonArrayMu.Unlock()
+	if jsonArrayState == jsonArrayOpen {
+		fmt.Println("\n]")
+	} else if jsonArrayState == jsonArrayUnopened {
+		fmt.Println("[]")
+	}
+	jsonArrayState = jsonArrayClosed
+}
+
+// jsonOutput reports whether results should be printed as JSON of either
+// kind rather than as text.
+func jsonOutput() bool {
+	return outputFormat == "json" || outputFormat == "jsonl"
+}
+
+// genericJSON returns v as encoding/json would decode its JSON into an
+// interface{}: maps, slices, strings, bools and float64s, which is also what
+// gojq takes.
+func genericJSON(v interface{}) (interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(buf, &generic)
+	return generic, err
+}
+
+// stripEmpty drops the fields of every object in v, a value from genericJSON,
+// that are null, "", false, 0, or an object or array left empty. Elements of
+// arrays are kept whatever they hold, so their positions don't shift.
+func stripEmpty(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			field = stripEmpty(field)
+			if isEmpty(field) {
+				delete(v, key)
+			} else {
+				v[key] = field
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = stripEmpty(v[i])
+		}
+	}
+	return v
+}
+
+func isEmpty(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/itchyny/gojq"
+)
+
+func TestStripEmpty(t *testing.T) {
+	v, err := genericJSON(map[string]interface{}{
+		"name":   "Kitchen",
+		"level":  0,
+		"power":  42,
+		"note":   "",
+		"on":     false,
+		"parent": nil,
+		"rooms":  []string{},
+		"loads":  []interface{}{map[string]interface{}{"llid": "a", "lpids": nil}, ""},
+		"nested": map[string]interface{}{"empty": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := json.Marshal(stripEmpty(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an array keeps its empty elements in place
+	want := `{"loads":[{"llid":"a"},""],"name":"Kitchen","power":42}`
+	if string(buf) != want {
+		t.Errorf("stripEmpty gave %s, want %s", buf, want)
+	}
+}
+
+func TestJSONArray(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	jsonArray = true
+	defer func() {
+		os.Stdout = stdout
+		jsonArray, jsonArrayState = false, jsonArrayUnopened
+	}()
+
+	printLine([]byte(`{"level":1}`))
+	printLine([]byte(`{"level":2}`))
+	closeJSONArray()
+	// anything printed as the run exits is dropped
+	printLine([]byte(`{"level":3}`))
+	w.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var levels []struct{ Level int }
+	if err := json.Unmarshal(buf, &levels); err != nil {
+		t.Fatalf("%q isn't a JSON array: %s", buf, err)
+	}
+	if len(levels) != 2 || levels[0].Level != 1 || levels[1].Level != 2 {
+		t.Errorf("got %+v, want levels 1 and 2", levels)
+	}
+}
+
+func TestRunJQ(t *testing.T) {
+	v, err := genericJSON(map[string]interface{}{"name": "Kitchen", "level": 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { jqQuery = nil }()
+
+	jqQuery, err = gojq.Parse(".name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := runJQ(v)
+	if err != nil || len(results) != 1 || results[0] != "Kitchen" {
+		t.Errorf("runJQ(.name) = %v, %v", results, err)
+	}
+
+	// indexing a string is an error in jq, and nothing is printed
+	jqQuery, err = gojq.Parse(".name.first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, err := runJQ(v); err == nil || results != nil {
+		t.Errorf("runJQ(.name.first) = %v, %v; want an error", results, err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// padAddress is where a Lightpad was last heard announcing itself.
+type padAddress struct {
+	IP    string    `json:"ip"`
+	Port  int       `json:"port"`
+	Heard time.Time `json:"heard"`
+}
+
+// padCachePath is where Lightpad addresses are remembered between runs, keyed
+// by Lightpad ID.
+func padCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plumcliraw", "pads.json"), nil
+}
+
+// readPadCache returns the cached pad addresses, or an empty cache if there
+// isn't one yet.
+func readPadCache() (map[string]padAddress, error) {
+	pads := make(map[string]padAddress)
+	path, err := padCachePath()
+	if err != nil {
+		return pads, err
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pads, nil
+	}
+	if err != nil {
+		return pads, err
+	}
+	if err := json.Unmarshal(buf, &pads); err != nil {
+		return pads, fmt.Errorf("failed to parse Lightpad address cache %s: %s", path, err)
+	}
+	return pads, nil
+}
+
+// cachePadAddress remembers addr as where Lightpad lpid was last heard.
+func cachePadAddress(lpid string, addr padAddress) error {
+	pads, err := readPadCache()
+	if err != nil {
+		return err
+	}
+	pads[lpid] = addr
+	path, err := padCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(pads, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0600)
+}
+
+// cachedPadAddress returns the cached address of Lightpad lpid if it was
+// heard within --discovery-ttl. It reports false if there's no such address
+// or the cache mustn't be used: with --refresh-discovery or in --test.
+func cachedPadAddress(lpid string, options Options) (padAddress, bool) {
+	if options.RefreshDiscovery || options.DiscoveryTTL <= 0 || options.TestMode {
+		return padAddress{}, false
+	}
+	pads, err := readPadCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring the Lightpad address cache: %s\n", err)
+		return padAddress{}, false
+	}
+	addr, ok := pads[lpid]
+	if !ok || time.Since(addr.Heard) >= options.DiscoveryTTL {
+		return padAddress{}, false
+	}
+	return addr, true
+}
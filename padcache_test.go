@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachedPadAddress(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	options := Options{DiscoveryTTL: time.Hour}
+	if _, ok := cachedPadAddress("lp1", options); ok {
+		t.Fatal("found an address in an empty cache")
+	}
+	if err := cachePadAddress("lp1", padAddress{IP: "10.0.0.5", Port: 8443, Heard: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cachePadAddress("lp2", padAddress{IP: "10.0.0.6", Port: 8443, Heard: time.Now().Add(-2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if addr, ok := cachedPadAddress("lp1", options); !ok || addr.IP != "10.0.0.5" || addr.Port != 8443 {
+		t.Errorf("cachedPadAddress(lp1) = %+v, %v", addr, ok)
+	}
+	// heard longer ago than --discovery-ttl
+	if _, ok := cachedPadAddress("lp2", options); ok {
+		t.Error("used an expired address")
+	}
+	options.RefreshDiscovery = true
+	if _, ok := cachedPadAddress("lp1", options); ok {
+		t.Error("used the cache with --refresh-discovery")
+	}
+}
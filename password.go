@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// readPassword fills in options.Password when the web API needs it: from the
+// first line of stdin with --password-stdin, otherwise by prompting on the
+// terminal with echo off if --password wasn't given.
+func readPassword(options *Options) error {
+	if options.PasswordStdin {
+		if options.Password != "" {
+			return fmt.Errorf("--password and --password-stdin can't be used together")
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password from stdin: %s", err)
+		}
+		options.Password = strings.TrimRight(line, "\r\n")
+		return nil
+	}
+	if options.Password != "" {
+		return nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("no password given; use --password, PLUM_PASSWORD or --password-stdin")
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", options.Email)
+	buf, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("failed to read password: %s", err)
+	}
+	options.Password = string(buf)
+	return nil
+}
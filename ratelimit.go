@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// dropReportInterval is how often eventLimiter reports what it has dropped.
+const dropReportInterval = 10 * time.Second
+
+// eventLimiter passes at most max events in each one second window and
+// drops the rest, so a misbehaving pad can't flood whatever is consuming our
+// output. Drops are counted and reported on stderr every dropReportInterval,
+// whether or not more events arrive, and by report on the way out.
+type eventLimiter struct {
+	mu         sync.Mutex
+	max        int
+	window     time.Time
+	count      int
+	dropped    int
+	lastReport time.Time
+}
+
+func newEventLimiter(max int) *eventLimiter {
+	now := time.Now()
+	l := &eventLimiter{
+		max:        max,
+		window:     now,
+		lastReport: now,
+	}
+	go func() {
+		for range time.Tick(dropReportInterval) {
+			l.report()
+		}
+	}()
+	return l
+}
+
+// allow reports whether an event arriving at now should be passed on.
+func (l *eventLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.window) >= time.Second {
+		l.window = now
+		l.count = 0
+	}
+	if l.count >= l.max {
+		l.dropped++
+		return false
+	}
+	l.count++
+	return true
+}
+
+// report prints how many events have been dropped since the last report, if
+// any were.
+func (l *eventLimiter) report() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: dropped %d events over --max-events-per-second in the last %s\n",
+			l.dropped, now.Sub(l.lastReport).Round(time.Second))
+		l.dropped = 0
+	}
+	l.lastReport = now
+}
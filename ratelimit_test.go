@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventLimiterWindow(t *testing.T) {
+	l := newEventLimiter(3)
+	start := l.window
+	passed := 0
+	for i := 0; i < 10; i++ {
+		if l.allow(start.Add(time.Duration(i) * 10 * time.Millisecond)) {
+			passed++
+		}
+	}
+	if passed != 3 || l.dropped != 7 {
+		t.Errorf("burst of 10: passed %d and dropped %d, want 3 and 7", passed, l.dropped)
+	}
+	if !l.allow(start.Add(time.Second)) {
+		t.Error("first event of the next window was dropped")
+	}
+}
+
+func TestEventLimiterReport(t *testing.T) {
+	l := newEventLimiter(1)
+	now := time.Now()
+	l.allow(now)
+	l.allow(now)
+	l.allow(now)
+	// report is what the ticker and the exit hook call
+	l.report()
+	if l.dropped != 0 {
+		t.Errorf("dropped = %d after report, want the count reset to 0", l.dropped)
+	}
+}
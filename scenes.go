@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// resolveScenes fetches the description of each scene in sids concurrently.
+func resolveScenes(conn libplumraw.WebConnection, sids libplumraw.Scenes) ([]libplumraw.Scene, error) {
+	w := &treeWalker{conn: conn, sem: make(chan struct{}, treeFetchers)}
+	scenes := make([]libplumraw.Scene, len(sids))
+	err := w.each(len(sids), func(i int) error {
+		return w.fetch(func() (err error) {
+			scenes[i], err = conn.GetScene(sids[i])
+			return err
+		})
+	})
+	return scenes, err
+}
+
+// printScenes prints the ID and name of each scene, one per line.
+func printScenes(scenes []libplumraw.Scene) {
+	if outputFormat != "debug" {
+		printResult(scenes)
+		return
+	}
+	for _, scene := range scenes {
+		fmt.Printf("%s\t%s\n", scene.ID, scene.Name)
+	}
+}
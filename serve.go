@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// serveLoad is how GET /loads describes a logical load.
+type serveLoad struct {
+	ID        string   `json:"llid"`
+	Name      string   `json:"name"`
+	Room      string   `json:"room"`
+	House     string   `json:"house"`
+	Lightpads []string `json:"lpids"`
+	// Reachable is whether a heartbeat has been heard from any of the
+	// load's Lightpads, so it can be controlled
+	Reachable bool `json:"reachable"`
+}
+
+// servePad is what Serve knows about a Lightpad: the load it controls and
+// its house's HAT from the web API, and its address from its heartbeat.
+type servePad struct {
+	llid string
+	hat  string
+	ip   net.IP
+	port int
+}
+
+// server is the state behind the Serve action's REST API.
+type server struct {
+	options Options
+	conn    libplumraw.WebConnection
+
+	mu         sync.Mutex
+	loads      []serveLoad
+	pads       map[string]*servePad
+	subscribed map[string]bool
+	clients    map[chan []byte]bool
+
+	events chan padEvent
+}
+
+// runServe keeps the web and Lightpad connections open and serves a REST API
+// on --listen for other tools to use them:
+//
+//	GET  /loads              every load in every house, as JSON, from the
+//	                         topology refetched every --cache-ttl
+//	POST /loads/<llid>/level set a load's level, from {"level": <0-255>}
+//	GET  /events             the events of every pad as server-sent events
+func runServe(conn libplumraw.WebConnection, options Options) {
+	// Serve outlives the single run a memoConn is meant for, so look things
+	// up afresh each time instead
+	if memo, ok := conn.(*memoConn); ok {
+		conn = memo.conn
+	}
+	s := &server{
+		options:    options,
+		conn:       conn,
+		pads:       make(map[string]*servePad),
+		subscribed: make(map[string]bool),
+		clients:    make(map[chan []byte]bool),
+		events:     make(chan padEvent),
+	}
+	if err := s.refresh(false); err != nil {
+		// Plum's cloud being down mustn't stop the pads being served; a
+		// stale topology beats none, and refreshEvery keeps trying
+		cache, cacheErr := readTopologyCache()
+		if cacheErr != nil || cache == nil || options.TestMode {
+			checkError(err)
+		}
+		fmt.Fprintf(os.Stderr, "Warning: failed to fetch the topology, serving the one cached %s ago: %s\n",
+			time.Since(cache.Fetched).Round(time.Second), err)
+		s.setTopology(cache.Houses)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.refreshEvery(ctx, serveRefreshInterval(options))
+	go s.watchHeartbeats()
+	go s.broadcast()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loads", s.handleLoads)
+	mux.HandleFunc("/loads/", s.handleLevel)
+	mux.HandleFunc("/events", s.handleEvents)
+	fmt.Printf("serving the REST API on %s\n", options.Listen)
+	err := http.ListenAndServe(options.Listen, mux)
+	cancel()
+	checkError(err)
+}
+
+// serveRefreshInterval is how often Serve refetches the topology: every
+// --cache-ttl, but no more often than --min-interval, or hourly if the
+// topology isn't cached.
+func serveRefreshInterval(options Options) time.Duration {
+	if options.CacheTTL > 0 {
+		return clampInterval("--cache-ttl", options.CacheTTL, options)
+	}
+	return time.Hour
+}
+
+// refreshEvery refetches the topology every interval, so requests can be
+// answered from what Serve already knows rather than by asking Plum's cloud.
+// If a refetch fails, the topology from the last one is kept.
+// It stops when ctx is done.
+func (s *server) refreshEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.refresh(true); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to refresh the topology: %s\n", err)
+		}
+	}
+}
+
+// refresh loads every house's topology, from the topology cache if it's
+// fresh enough unless force is set, and subscribes to any pads it newly
+// knows how to reach.
+func (s *server) refresh(force bool) error {
+	options := s.options
+	options.Refresh = options.Refresh || force
+	trees, err := houseTrees(newMemoConn(s.conn), options)
+	if err != nil {
+		return err
+	}
+	s.setTopology(trees)
+	return nil
+}
+
+// setTopology replaces the loads Serve knows of with those in trees, and
+// subscribes to any pads it newly knows how to reach.
+func (s *server) setTopology(trees []houseTree) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads = nil
+	for _, house := range trees {
+		for _, room := range house.Rooms {
+			for _, load := range room.Loads {
+				s.loads = append(s.loads, serveLoad{
+					ID:        load.ID,
+					Name:      load.Name,
+					Room:      room.Name,
+					House:     house.Name,
+					Lightpads: load.LPIDs,
+				})
+				for _, lpid := range load.LPIDs {
+					pad, ok := s.pads[lpid]
+					if !ok {
+						pad = &servePad{}
+						s.pads[lpid] = pad
+					}
+					pad.llid, pad.hat = load.ID, house.AccessToken
+					s.subscribe(lpid)
+				}
+			}
+		}
+	}
+}
+
+// watchHeartbeats keeps the pads' addresses up to date for as long as Serve
+// runs.
+func (s *server) watchHeartbeats() {
+	for ann := range listenHeartbeats(context.Background(), s.options) {
+		s.mu.Lock()
+		pad, ok := s.pads[ann.ID]
+		if !ok {
+			pad = &servePad{}
+			s.pads[ann.ID] = pad
+		}
+		pad.ip, pad.port = ann.IP, ann.Port
+		s.subscribe(ann.ID)
+		s.mu.Unlock()
+	}
+}
+
+// padOptions returns the options for talking to pad. s.mu must be held.
+func (s *server) padOptions(pad *servePad) Options {
+	options := s.options
+	options.ID, options.HAT = pad.llid, pad.hat
+	options.LightpadIP, options.Port = pad.ip.String(), pad.port
+	return options
+}
+
+// subscribe starts listening to the events of Lightpad lpid once both its
+// address and HAT are known. s.mu must be held.
+func (s *server) subscribe(lpid string) {
+	pad := s.pads[lpid]
+	if s.subscribed[lpid] || pad.ip == nil || pad.hat == "" {
+		return
+	}
+	s.subscribed[lpid] = true
+	options := s.padOptions(pad)
+	go func() {
+		stateChanges := make(chan libplumraw.Event, 0)
+		if err := newLightpad(options, stateChanges).Subscribe(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to subscribe to Lightpad %s: %s\n", lpid, err)
+			s.mu.Lock()
+			s.subscribed[lpid] = false
+			s.mu.Unlock()
+			return
+		}
+		listen(options, nil, stateChanges, s.events)
+		// the pad is gone for now; subscribe again when it's next heard
+		s.mu.Lock()
+		s.subscribed[lpid] = false
+		s.mu.Unlock()
+	}()
+}
+
+// broadcast sends every event to each /events client. Clients that aren't
+// keeping up miss events rather than hold up the rest.
+func (s *server) broadcast() {
+	for pe := range s.events {
+		buf, err := json.Marshal(newEventRecord(time.Now(), pe, false))
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		for client := range s.clients {
+			select {
+			case client <- buf:
+			default:
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *server) handleLoads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	loads := make([]serveLoad, len(s.loads))
+	for i, load := range s.loads {
+		for _, lpid := range load.Lightpads {
+			if pad := s.pads[lpid]; pad != nil && pad.ip != nil {
+				load.Reachable = true
+			}
+		}
+		loads[i] = load
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loads)
+}
+
+func (s *server) handleLevel(w http.ResponseWriter, r *http.Request) {
+	llid := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/loads/"), "/level")
+	if llid == "" || strings.Contains(llid, "/") || !strings.HasSuffix(r.URL.Path, "/level") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Level *int `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Level == nil || *body.Level < 0 || *body.Level > 255 {
+		http.Error(w, `body must be {"level": <0-255>}`, http.StatusBadRequest)
+		return
+	}
+	level := *body.Level
+	if s.options.LevelStep > 0 {
+		level = snapLevel(level, s.options.LevelStep)
+	}
+
+	var options *Options
+	s.mu.Lock()
+	for _, pad := range s.pads {
+		if pad.llid == llid && pad.ip != nil && pad.hat != "" {
+			o := s.padOptions(pad)
+			options = &o
+			break
+		}
+	}
+	s.mu.Unlock()
+	if options == nil {
+		http.Error(w, fmt.Sprintf("no reachable Lightpad controls load %s", llid), http.StatusNotFound)
+		return
+	}
+	if err := newLightpad(*options, nil).SetLogicalLoadLevel(level); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan []byte, 16)
+	s.mu.Lock()
+	s.clients[client] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case buf := <-client:
+			fmt.Fprintf(w, "data: %s\n\n", buf)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRefreshEveryStops(t *testing.T) {
+	s := &server{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.refreshEvery(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("refreshEvery kept running after its context was cancelled")
+	}
+}
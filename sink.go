@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// metricSink is where power and dimmer readings are written in InfluxDB line
+// protocol, set from --sink. It is nil when there is no --sink.
+var metricSink *lineSink
+
+// lineSink writes readings as InfluxDB line protocol, either to an InfluxDB
+// server's v1 write endpoint or appended to a file.
+type lineSink struct {
+	// writeURL is the InfluxDB write endpoint, or "" when writing to file
+	writeURL string
+	file     *os.File
+	// tags is the pre-escaped tag set every point carries
+	tags string
+}
+
+// newLineSink opens the sink described by dest, which is either
+// influxdb://[user:pass@]host:port/db (influxdbs:// for TLS), or a file
+// path, optionally as a file:// URL. Every point is tagged with tags.
+func newLineSink(dest string, tags map[string]string) (*lineSink, error) {
+	s := &lineSink{tags: formatTags(tags)}
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme == "" || u.Scheme == "file" {
+		path := dest
+		if err == nil && u.Scheme == "file" {
+			path = u.Path
+		}
+		s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	scheme := map[string]string{"influxdb": "http", "influxdbs": "https"}[u.Scheme]
+	db := strings.Trim(u.Path, "/")
+	if scheme == "" || db == "" {
+		return nil, fmt.Errorf("--sink %s should be influxdb://host:port/db or a file", dest)
+	}
+	q := url.Values{"db": {db}, "precision": {"ns"}}
+	if u.User != nil {
+		q.Set("u", u.User.Username())
+		if pass, ok := u.User.Password(); ok {
+			q.Set("p", pass)
+		}
+	}
+	s.writeURL = (&url.URL{Scheme: scheme, Host: u.Host, Path: "/write", RawQuery: q.Encode()}).String()
+	return s, nil
+}
+
+// write records one reading from the given Lightpad.
+func (s *lineSink) write(measurement, pad, field string, value int, t time.Time) error {
+	line := fmt.Sprintf("%s%s,lightpad=%s %s=%di %d\n", measurement, s.tags, escapeTag(pad), field, value, t.UnixNano())
+	if s.file != nil {
+		_, err := s.file.WriteString(line)
+		return err
+	}
+	resp, err := http.Post(s.writeURL, "text/plain", bytes.NewBufferString(line))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB write failed with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// writeMetrics records the power and level in mets.
+func (s *lineSink) writeMetrics(mets libplumraw.LogicalLoadMetrics, pad string, t time.Time) error {
+	if err := s.write("plum_power", pad, "watts", mets.Power, t); err != nil {
+		return err
+	}
+	return s.write("plum_level", pad, "level", mets.Level, t)
+}
+
+func (s *lineSink) Close() error {
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+// formatTags returns tags as a line protocol tag set, sorted by key as
+// InfluxDB prefers, leaving out empty values.
+func formatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, ",%s=%s", k, escapeTag(tags[k]))
+	}
+	return b.String()
+}
+
+// escapeTag escapes the characters line protocol treats specially in tags.
+func escapeTag(v string) string {
+	return strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`).Replace(v)
+}
+
+// sinkTags returns the tags to put on the readings of load llid: its ID,
+// and, when conn isn't nil, the names of its room and house.
+func sinkTags(conn libplumraw.WebConnection, llid string) map[string]string {
+	tags := map[string]string{"llid": llid}
+	if conn == nil || llid == "" {
+		return tags
+	}
+	load, err := conn.GetLogicalLoad(llid)
+	if err == nil {
+		var room libplumraw.Room
+		room, err = conn.GetRoom(load.RoomID)
+		if err == nil {
+			tags["room"] = room.Name
+			var house libplumraw.House
+			house, err = conn.GetHouse(room.HouseID)
+			tags["house"] = house.Name
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to look up room and house of load %s for --sink tags: %s\n", llid, err)
+	}
+	return tags
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// sqliteBatchSize and sqliteBatchAge bound how many events are held in
+	// an open transaction, and for how long, before being committed.
+	sqliteBatchSize = 100
+	sqliteBatchAge  = time.Second
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS events (
+	timestamp TEXT NOT NULL,
+	pad       TEXT NOT NULL,
+	type      TEXT NOT NULL,
+	value     INTEGER
+)`
+
+// sqliteSink records events in a SQLite database. Inserts are batched into
+// transactions, since committing each event separately is slow; a batch is
+// committed once it's full, and otherwise every sqliteBatchAge so a quiet pad's
+// events aren't held back.
+type sqliteSink struct {
+	mu      sync.Mutex
+	db      *sql.DB
+	tx      *sql.Tx
+	insert  *sql.Stmt
+	pending int
+	done    chan struct{}
+}
+
+func newSQLiteSink(path string) (*sqliteSink, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &sqliteSink{db: db, done: make(chan struct{})}
+	if err := s.begin(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	go s.commitEvery(sqliteBatchAge)
+	return s, nil
+}
+
+func (s *sqliteSink) begin() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	insert, err := tx.Prepare("INSERT INTO events (timestamp, pad, type, value) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	s.tx, s.insert, s.pending = tx, insert, 0
+	return nil
+}
+
+// commit commits the current batch and begins the next. s.mu must be held.
+func (s *sqliteSink) commit() error {
+	if err := s.tx.Commit(); err != nil {
+		return err
+	}
+	return s.begin()
+}
+
+// commitEvery commits whatever has been written every interval, until the
+// sink is closed.
+func (s *sqliteSink) commitEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.pending > 0 {
+				if err := s.commit(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to commit events to SQLite: %s\n", err)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// write adds an event, committing the current batch if it is full.
+func (s *sqliteSink) write(ts time.Time, pad, eventType string, value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.insert.Exec(ts.UTC().Format(time.RFC3339Nano), pad, eventType, value)
+	if err != nil {
+		return err
+	}
+	s.pending++
+	if s.pending >= sqliteBatchSize {
+		return s.commit()
+	}
+	return nil
+}
+
+// Close commits any outstanding events and closes the database.
+func (s *sqliteSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.done)
+	err := s.tx.Commit()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
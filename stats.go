@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// requestStats accumulates totals over every request made by the command, for
+// --stats.
+type requestStats struct {
+	mu        sync.Mutex
+	requests  int
+	bytesSent int64
+	bytesRecv int64
+	latency   time.Duration
+}
+
+// commandStats is shared by every statsTransport; it is nil unless --stats
+// was given.
+var commandStats *requestStats
+
+func (s *requestStats) add(sent, recv int64, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.bytesSent += sent
+	s.bytesRecv += recv
+	s.latency += latency
+}
+
+func (s *requestStats) addRecv(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytesRecv += n
+}
+
+func (s *requestStats) print() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var avg time.Duration
+	if s.requests > 0 {
+		avg = s.latency / time.Duration(s.requests)
+	}
+	fmt.Fprintf(os.Stderr, "requests: %d, sent: %d bytes, received: %d bytes, wall time: %s, average latency: %s\n",
+		s.requests, s.bytesSent, s.bytesRecv, time.Since(startTime).Round(time.Millisecond), avg.Round(time.Millisecond))
+}
+
+// statsTransport records the size and latency of each request in stats.
+// Latency is measured to the response headers; bytes received are counted
+// as the body is read.
+type statsTransport struct {
+	base  http.RoundTripper
+	stats *requestStats
+}
+
+func (t *statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	var sent int64
+	if req.ContentLength > 0 {
+		sent = req.ContentLength
+	}
+	t.stats.add(sent, 0, time.Since(start))
+	if resp != nil {
+		resp.Body = &countingReader{ReadCloser: resp.Body, stats: t.stats}
+	}
+	return resp, err
+}
+
+type countingReader struct {
+	io.ReadCloser
+	stats *requestStats
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.stats.addRecv(int64(n))
+	return n, err
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// subscriber prints the events heard from one or more Lightpads and reacts
+// to them as the options ask.
+type subscriber struct {
+	options Options
+	pads    map[string]*subscribedPad
+	limiter *eventLimiter
+	sqlite  *sqliteSink
+	// slots holds a token for each open subscription under
+	// --max-concurrent-subscribes, and is nil without a limit
+	slots chan struct{}
+	// labeled events say which pad they came from even in prose, because
+	// several pads are being listened to
+	labeled bool
+}
+
+// subscribedPad is one of the Lightpads being listened to. Its lp talks to
+// the pad's load for --keepalive, --power-glow and --emit-initial-state,
+// because the subscription's own pad is replaced each time it reconnects.
+type subscribedPad struct {
+	options Options
+	lp      lightpad
+	sub     *subscription
+}
+
+// subscription holds the way to cancel a Lightpad's current event stream, so
+// that something other than the pad can have it dropped and resubscribed. A
+// nil subscription can't be dropped.
+type subscription struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// context returns the context for a new stream, cancelling which drops it.
+func (sub *subscription) context() context.Context {
+	if sub == nil {
+		return context.Background()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sub.mu.Lock()
+	sub.cancel = cancel
+	sub.mu.Unlock()
+	return ctx
+}
+
+// drop closes the current stream, which has listen resubscribe.
+func (sub *subscription) drop() {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.cancel != nil {
+		sub.cancel()
+	}
+}
+
+// padEvent is an event along with the address of the Lightpad it came from.
+type padEvent struct {
+	pad string
+	ev  libplumraw.Event
+}
+
+// subscribePads returns the options for each Lightpad to subscribe to: every
+// pad heard announcing itself with --all, or else each of the comma separated
+// addresses in --lpip, each with its HAT from --hats or --hat and its load's
+// ID from --id.
+func subscribePads(options Options) []Options {
+	pads := subscribeAddresses(options)
+	for i := range pads {
+		pads[i].HAT = padHAT(pads[i].LightpadIP, pads[i].LightpadID, options.HAT)
+		if pads[i].HAT == "" && !options.TestMode {
+			fmt.Printf("--hats has no HAT for Lightpad %s, and no --hat was given\n", pads[i].LightpadIP)
+			exit(1)
+		}
+	}
+	if options.ID == "" && !subscribeNeedsID(options) {
+		return pads
+	}
+	ids := strings.Split(options.ID, ",")
+	if len(ids) != len(pads) {
+		if options.All {
+			fmt.Println("heartbeats don't say which load each Lightpad controls; list the pads with --lpip and their Logical Load IDs with --id, in the same order, instead of --all")
+		} else {
+			fmt.Printf("--id has %d Logical Load IDs for %d Lightpads; give one per --lpip, in the same order\n", len(ids), len(pads))
+		}
+		exit(1)
+	}
+	for i := range pads {
+		pads[i].ID = strings.TrimSpace(ids[i])
+	}
+	return pads
+}
+
+// subscribeAddresses returns the options for each Lightpad to subscribe to
+// as subscribePads does, without their loads' IDs.
+func subscribeAddresses(options Options) []Options {
+	var pads []Options
+	if options.All {
+		ctx, cancel := context.WithTimeout(context.Background(), options.DiscoverFor)
+		defer cancel()
+		seen := make(map[string]bool)
+		for ann := range listenHeartbeats(ctx, options) {
+			if seen[ann.ID] {
+				continue
+			}
+			seen[ann.ID] = true
+			pad := options
+			pad.LightpadIP, pad.Port, pad.LightpadID = ann.IP.String(), ann.Port, ann.ID
+			pads = append(pads, pad)
+		}
+		if len(pads) == 0 {
+			checkError(fmt.Errorf("no Lightpads heard announcing themselves within %s", options.DiscoverFor))
+		}
+		return pads
+	}
+	for _, ip := range strings.Split(options.LightpadIP, ",") {
+		pad := options
+		pad.LightpadIP = strings.TrimSpace(ip)
+		pads = append(pads, pad)
+	}
+	return pads
+}
+
+// runSubscribe listens to the Lightpads described by options and handles
+// their events, merged into one stream, until every pad has closed its
+// connection.
+func runSubscribe(options Options) {
+	pads := subscribePads(options)
+	if options.Since > 0 {
+		// Lightpads only stream changes as they happen; there is no
+		// history to replay from.
+		fmt.Fprintf(os.Stderr, "Warning: Lightpads don't buffer events, ignoring --since %s\n", options.Since)
+	}
+	if options.EventsOnly && options.TelemetryOnly {
+		fmt.Println("--events-only and --telemetry-only can't be used together")
+		exit(1)
+	}
+	if options.PowerGlow {
+		if options.WattsMax <= 0 {
+			fmt.Println("--watts-max must be greater than 0")
+			exit(1)
+		}
+	}
+	if options.MaxConcurrentSubscribes < 0 {
+		fmt.Println("--max-concurrent-subscribes can't be negative")
+		exit(1)
+	}
+	if options.EmitInitialState && options.ConcurrentPadReads < 1 {
+		fmt.Println("--concurrent-pad-reads must be at least 1")
+		exit(1)
+	}
+
+	s := &subscriber{
+		options: options,
+		pads:    make(map[string]*subscribedPad),
+		labeled: len(pads) > 1,
+	}
+	if options.MaxConcurrentSubscribes > 0 {
+		s.slots = make(chan struct{}, options.MaxConcurrentSubscribes)
+	}
+	subscribed := make([]*subscribedPad, len(pads))
+	events := make(chan padEvent)
+	var wg sync.WaitGroup
+	var queued []*subscribedPad
+	for i, pad := range pads {
+		p := &subscribedPad{options: pad}
+		if options.Keepalive > 0 {
+			// keepalive drops the stream when the pad stops answering,
+			// which is no use unless it's then resubscribed
+			p.sub = &subscription{}
+			if p.options.MaxReconnects == 0 {
+				p.options.MaxReconnects = -1
+			}
+		}
+		p.lp = newLightpad(p.options, nil)
+		s.pads[pad.LightpadIP] = p
+		subscribed[i] = p
+
+		wg.Add(1)
+		if !s.acquire(false) {
+			queued = append(queued, p)
+			continue
+		}
+		checkPadError(s.subscribe(p, events, &wg))
+	}
+	if len(queued) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: --max-concurrent-subscribes %d: %d Lightpads wait until a subscription ends\n",
+			options.MaxConcurrentSubscribes, len(queued))
+		go func() {
+			for _, p := range queued {
+				s.acquire(true)
+				if err := s.subscribe(p, events, &wg); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to subscribe to Lightpad %s: %s\n", p.options.LightpadIP, err)
+					s.release()
+					wg.Done()
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	if options.MaxEventsPerSecond > 0 {
+		s.limiter = newEventLimiter(options.MaxEventsPerSecond)
+		atExit(s.limiter.report)
+	}
+	if options.SQLite != "" {
+		var err error
+		s.sqlite, err = newSQLiteSink(options.SQLite)
+		checkError(err)
+		atExit(func() {
+			if err := s.sqlite.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to commit events to SQLite: %s\n", err)
+			}
+		})
+	}
+	if options.EmitInitialState {
+		s.emitInitialState(subscribed)
+	}
+	for pe := range events {
+		s.handle(pe, false)
+	}
+}
+
+// acquire takes a subscription slot, waiting for one to be released if wait
+// is set, and reports whether it got one. Without --max-concurrent-subscribes
+// there's always a slot.
+func (s *subscriber) acquire(wait bool) bool {
+	if s.slots == nil {
+		return true
+	}
+	if wait {
+		s.slots <- struct{}{}
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release gives up a slot taken by acquire.
+func (s *subscriber) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// subscribe opens p's event stream and passes its events on to events until
+// listen gives up on the pad. Then wg is marked done and p's slot released
+// for a queued pad.
+func (s *subscriber) subscribe(p *subscribedPad, events chan<- padEvent, wg *sync.WaitGroup) error {
+	stateChanges := make(chan libplumraw.Event, 0)
+	if outputFormat != "jsonl" {
+		// keep the jsonl stream to events only
+		fmt.Printf("unpacked %s\n", p.options.LightpadIP)
+	}
+	if err := newLightpad(p.options, stateChanges).Subscribe(p.sub.context()); err != nil {
+		return err
+	}
+	go func() {
+		defer wg.Done()
+		listen(p.options, p.sub, stateChanges, events)
+		s.release()
+	}()
+	if s.options.Keepalive > 0 {
+		go keepalive(p.options.LightpadIP, p.lp, s.options.Keepalive, p.sub)
+	}
+	return nil
+}
+
+// emitInitialState emits each pad's current level and power as initial
+// events, reading up to --concurrent-pad-reads pads at once. A pad that can't
+// be read is warned about and still reports its events as they happen.
+func (s *subscriber) emitInitialState(pads []*subscribedPad) {
+	mets := make([]libplumraw.LogicalLoadMetrics, len(pads))
+	errs := make([]error, len(pads))
+	sem := make(chan struct{}, s.options.ConcurrentPadReads)
+	var wg sync.WaitGroup
+	for i, p := range pads {
+		wg.Add(1)
+		go func(i int, p *subscribedPad) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			mets[i], errs[i] = p.lp.GetLogicalLoadMetrics()
+		}(i, p)
+	}
+	wg.Wait()
+	for i, p := range pads {
+		ip := p.options.LightpadIP
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to read the initial state of Lightpad %s: %s\n", ip, errs[i])
+			continue
+		}
+		s.handle(padEvent{ip, libplumraw.LPEDimmerChange{Type: "dimmerchange", Level: mets[i].Level}}, true)
+		s.handle(padEvent{ip, libplumraw.LPEPower{Type: "power", Watts: mets[i].Power}}, true)
+	}
+}
+
+// listen passes on the events from one Lightpad's subscription, labeled with
+// the pad's address, and resubscribes with backoff when the pad drops it, up
+// to --max-reconnects times in a row without hearing an event. Each new
+// stream is opened with sub's context, so sub can drop it too.
+func listen(options Options, sub *subscription, stateChanges chan libplumraw.Event, events chan<- padEvent) {
+	failed := 0
+	for {
+		heard := false
+		for ev := range stateChanges {
+			heard = true
+			events <- padEvent{options.LightpadIP, ev}
+		}
+		if heard {
+			failed = 0
+		}
+		for {
+			if options.MaxReconnects >= 0 && failed >= options.MaxReconnects {
+				if options.MaxReconnects > 0 {
+					fmt.Fprintf(os.Stderr, "Warning: giving up on Lightpad %s after %d reconnects\n", options.LightpadIP, failed)
+				}
+				return
+			}
+			failed++
+			delay := backoffDelay(failed, options.BackoffBase, options.BackoffCap)
+			fmt.Fprintf(os.Stderr, "Warning: lost connection to Lightpad %s, reconnecting in %s (attempt %d)\n", options.LightpadIP, delay, failed)
+			time.Sleep(delay)
+			stateChanges = make(chan libplumraw.Event, 0)
+			err := newLightpad(options, stateChanges).Subscribe(sub.context())
+			if err == nil {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "Warning: failed to reconnect to Lightpad %s: %s\n", options.LightpadIP, err)
+		}
+	}
+}
+
+// handle prints the event in pe. initial marks events synthesized from the
+// pad's state at startup rather than heard from the pad.
+func (s *subscriber) handle(pe padEvent, initial bool) {
+	ev := pe.ev
+	_, telemetry := ev.(libplumraw.LPEPower)
+	if (s.options.EventsOnly && telemetry) || (s.options.TelemetryOnly && !telemetry) {
+		return
+	}
+	if s.limiter != nil && !s.limiter.allow(time.Now()) {
+		return
+	}
+	now := time.Now()
+	if s.sqlite != nil {
+		eventType, value := eventValue(ev)
+		if err := s.sqlite.write(now, pe.pad, eventType, value); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record event in SQLite: %s\n", err)
+		}
+	}
+	if metricSink != nil {
+		var err error
+		switch ev := ev.(type) {
+		case libplumraw.LPEDimmerChange:
+			err = metricSink.write("plum_level", pe.pad, "level", ev.Level, now)
+		case libplumraw.LPEPower:
+			err = metricSink.write("plum_power", pe.pad, "watts", ev.Watts, now)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write event to --sink: %s\n", err)
+		}
+	}
+	if outputFormat == "jsonl" {
+		s.printJSON(now, pe, initial)
+	} else {
+		s.print(now, pe, initial)
+	}
+	switch ev := ev.(type) {
+	case libplumraw.LPEPower:
+		if s.options.PowerGlow {
+			p := s.pads[pe.pad]
+			err := p.lp.SetLogicalLoadGlow(powerGlow(p.options.ID, ev.Watts, s.options.WattsMax))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to set power glow on Lightpad %s: %s\n", pe.pad, err)
+			}
+		}
+	case libplumraw.LPEUnknown:
+		if s.options.FailOnUnknownEvent {
+			checkError(fmt.Errorf("unknown event type from Lightpad: %s", ev.Message))
+		}
+	}
+}
+
+// print describes the event in pe in a line of prose.
+func (s *subscriber) print(now time.Time, pe padEvent, initial bool) {
+	fmt.Printf("%s ", formatTime(now))
+	if s.labeled {
+		fmt.Printf("[%s] ", pe.pad)
+	}
+	if initial {
+		fmt.Print("[initial] ")
+	}
+	switch ev := pe.ev.(type) {
+	case libplumraw.LPEDimmerChange:
+		fmt.Printf("heard a %s event with value %s\n", ev.Type, formatLevel(ev.Level))
+		// spew.Dump(ev.(libplumraw.LPEDimmerChange))
+	case libplumraw.LPEPower:
+		fmt.Printf("heard a %s event with value %d\n", ev.Type, ev.Watts)
+		// spew.Dump(ev.(libplumraw.LPEPower))
+	case libplumraw.LPEPIRSignal:
+		fmt.Printf("heard a %s event with value %d\n", ev.Type, ev.Signal)
+		// lp.SetLogicalLoadLevel(255) // turn the light on in response to motion
+		// spew.Dump(ev.(libplumraw.LPEPower))
+	case libplumraw.LPEUnknown:
+		fmt.Printf("heard an unknown event with message %s\n", ev.Message)
+		// spew.Dump(ev.(libplumraw.LPEPower))
+	}
+}
+
+// eventRecord is the JSON form of an event for --output jsonl. Only the
+// field that belongs to the event's type is set.
+type eventRecord struct {
+	Timestamp string `json:"timestamp"`
+	Lightpad  string `json:"lightpad"`
+	Type      string `json:"type"`
+	Level     *int   `json:"level,omitempty"`
+	Watts     *int   `json:"watts,omitempty"`
+	Signal    *int   `json:"signal,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Initial   bool   `json:"initial,omitempty"`
+}
+
+// printJSON prints the event in pe as one line of JSON.
+func (s *subscriber) printJSON(now time.Time, pe padEvent, initial bool) {
+	buf, err := json.Marshal(newEventRecord(now, pe, initial))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to marshal event: %s\n", err)
+		return
+	}
+	printLine(buf)
+}
+
+// newEventRecord returns the JSON form of the event in pe.
+func newEventRecord(now time.Time, pe padEvent, initial bool) eventRecord {
+	rec := eventRecord{
+		Timestamp: formatTime(now),
+		Lightpad:  pe.pad,
+		Initial:   initial,
+	}
+	switch ev := pe.ev.(type) {
+	case libplumraw.LPEDimmerChange:
+		rec.Type, rec.Level = ev.Type, &ev.Level
+	case libplumraw.LPEPower:
+		rec.Type, rec.Watts = ev.Type, &ev.Watts
+	case libplumraw.LPEPIRSignal:
+		rec.Type, rec.Signal = ev.Type, &ev.Signal
+	case libplumraw.LPEUnknown:
+		rec.Type, rec.Message = "unknown", ev.Message
+	}
+	return rec
+}
+
+// eventValue returns the type of ev and the single number it carries: the
+// level, watts or signal strength. Unknown events carry no value.
+func eventValue(ev libplumraw.Event) (string, int) {
+	switch ev := ev.(type) {
+	case libplumraw.LPEDimmerChange:
+		return ev.Type, ev.Level
+	case libplumraw.LPEPower:
+		return ev.Type, ev.Watts
+	case libplumraw.LPEPIRSignal:
+		return ev.Type, ev.Signal
+	}
+	return "unknown", 0
+}
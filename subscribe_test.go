@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maplebed/libplumraw"
+)
+
+func TestSubscribePadsLoadIDs(t *testing.T) {
+	options := Options{LightpadIP: "10.0.0.1, 10.0.0.2", HAT: "hat", ID: "aaa, bbb", EmitInitialState: true}
+	pads := subscribePads(options)
+	if len(pads) != 2 {
+		t.Fatalf("got %d pads, want 2", len(pads))
+	}
+	for i, want := range [][2]string{{"10.0.0.1", "aaa"}, {"10.0.0.2", "bbb"}} {
+		if pads[i].LightpadIP != want[0] || pads[i].ID != want[1] {
+			t.Errorf("pad %d is %s with load %s, want %s with %s", i, pads[i].LightpadIP, pads[i].ID, want[0], want[1])
+		}
+	}
+}
+
+// deadPad is a Lightpad whose load can't be read.
+type deadPad struct {
+	*testLightpad
+}
+
+func (deadPad) GetLogicalLoadMetrics() (libplumraw.LogicalLoadMetrics, error) {
+	return libplumraw.LogicalLoadMetrics{}, errors.New("connection refused")
+}
+
+func TestEmitInitialStateSkipsUnreadablePads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.lp")
+	sink, err := newLineSink("file://"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricSink = sink
+	defer func() { metricSink = nil }()
+
+	s := &subscriber{options: Options{ConcurrentPadReads: 1}}
+	s.emitInitialState([]*subscribedPad{
+		{options: Options{LightpadIP: "10.0.0.1"}, lp: deadPad{makeTestLightpad(nil)}},
+		{options: Options{LightpadIP: "10.0.0.2"}, lp: makeTestLightpad(nil)},
+	})
+	sink.Close()
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "10.0.0.1") || strings.Count(string(buf), "lightpad=10.0.0.2") != 2 {
+		t.Errorf("wrote\n%s\nwant the level and power of 10.0.0.2 only", buf)
+	}
+}
+
+func TestSubscribeSlots(t *testing.T) {
+	s := &subscriber{slots: make(chan struct{}, 2)}
+	if !s.acquire(false) || !s.acquire(false) {
+		t.Fatal("couldn't take both slots")
+	}
+	if s.acquire(false) {
+		t.Fatal("took a third slot of two")
+	}
+	s.release()
+	if !s.acquire(false) {
+		t.Error("a released slot wasn't free")
+	}
+
+	// without --max-concurrent-subscribes there's no limit
+	s = &subscriber{}
+	for i := 0; i < 100; i++ {
+		if !s.acquire(false) {
+			t.Fatal("ran out of slots with no limit")
+		}
+	}
+}
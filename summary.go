@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runSummary describes how a run went. It is written to --summary-file on
+// exit so that calling systems can check the outcome without scraping
+// stdout.
+type runSummary struct {
+	Action    string   `json:"action"`
+	Successes int      `json:"success_count"`
+	Failures  int      `json:"failure_count"`
+	Duration  string   `json:"duration"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+var (
+	summary     runSummary
+	summaryFile string
+	startTime   = time.Now()
+)
+
+func recordSuccess() {
+	summary.Successes++
+}
+
+func recordFailure(err error) {
+	summary.Failures++
+	summary.Errors = append(summary.Errors, err.Error())
+}
+
+// exitHooks flush whatever is still buffered before exit, since os.Exit
+// skips deferred calls. exitMu is held from the moment exit starts, so that
+// a signal arriving as the run ends can't exit a second time.
+var (
+	exitHooks []func()
+	exitMu    sync.Mutex
+)
+
+// atExit has exit call hook, after any hooks added before it.
+func atExit(hook func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitHooks = append(exitHooks, hook)
+}
+
+// exitOnSignal has Ctrl-C and SIGTERM end the run through exit, so that the
+// exit hooks and the run summary are still written. For a run that only ends
+// when interrupted that's a success; otherwise the run was cut short and
+// exits 130, as a shell reports an interrupted command.
+func exitOnSignal(options Options) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		if runsUntilStopped(options) {
+			recordSuccess()
+			exit(0)
+		}
+		recordFailure(fmt.Errorf("interrupted by %s", sig))
+		exit(130)
+	}()
+}
+
+// exit runs the exit hooks, writes out the run summary and request stats (if
+// they were asked for) and exits with code.
+func exit(code int) {
+	exitMu.Lock()
+	for _, hook := range exitHooks {
+		hook()
+	}
+	if commandStats != nil {
+		commandStats.print()
+	}
+	if summaryFile != "" {
+		if code != 0 && code != exitEmpty && summary.Failures == 0 {
+			// failed flag validation rather than an operation
+			summary.Failures++
+		}
+		summary.Duration = time.Since(startTime).String()
+		buf, err := json.MarshalIndent(summary, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(summaryFile, append(buf, '\n'), 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write summary file: %s\n", err)
+		}
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// testLightpad stands in for a real Lightpad when running in --test mode. It
+// returns canned metrics, reports the changes it is asked to make, and plays
+// back a scripted list of events when subscribed to.
+type testLightpad struct {
+	Metrics      libplumraw.LogicalLoadMetrics
+	Events       []libplumraw.Event
+	StateChanges chan libplumraw.Event
+}
+
+func (t *testLightpad) GetLogicalLoadMetrics() (libplumraw.LogicalLoadMetrics, error) {
+	return t.Metrics, nil
+}
+
+func (t *testLightpad) SetLogicalLoadLevel(level int) error {
+	fmt.Printf("test lightpad: set level to %d\n", level)
+	t.Metrics.Level = level
+	return nil
+}
+
+func (t *testLightpad) SetLightpadConfig(conf libplumraw.LightpadConfig) error {
+	fmt.Printf("test lightpad: set lightpad config to %+v\n", conf)
+	return nil
+}
+
+func (t *testLightpad) SetLogicalLoadConfig(conf libplumraw.LogicalLoadConfig) error {
+	fmt.Printf("test lightpad: set load config to %+v\n", conf)
+	return nil
+}
+
+func (t *testLightpad) SetLogicalLoadGlow(glow libplumraw.ForceGlow) error {
+	fmt.Printf("test lightpad: set glow to %+v\n", glow)
+	return nil
+}
+
+// Subscribe sends each scripted event on StateChanges and then closes it, as
+// a real pad's channel is closed when the connection ends.
+func (t *testLightpad) Subscribe(ctx context.Context) error {
+	go func() {
+		defer close(t.StateChanges)
+		for _, ev := range t.Events {
+			select {
+			case t.StateChanges <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
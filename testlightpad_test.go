@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/maplebed/libplumraw"
+)
+
+func TestTestLightpadMetrics(t *testing.T) {
+	lp := newLightpad(Options{TestMode: true}, nil)
+	mets, err := lp.GetLogicalLoadMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := makeTestLightpad(nil).Metrics; !reflect.DeepEqual(mets, want) {
+		t.Errorf("GetLogicalLoadMetrics() = %+v, want the canned %+v", mets, want)
+	}
+
+	// a level that's set is what the metrics report next
+	if err := lp.SetLogicalLoadLevel(200); err != nil {
+		t.Fatal(err)
+	}
+	mets, err = lp.GetLogicalLoadMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mets.Level != 200 {
+		t.Errorf("level after SetLogicalLoadLevel(200) = %d", mets.Level)
+	}
+}
+
+func TestTestLightpadSubscribe(t *testing.T) {
+	options := Options{TestMode: true, LightpadIP: "test"}
+	stateChanges := make(chan libplumraw.Event, 0)
+	if err := newLightpad(options, stateChanges).Subscribe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan padEvent)
+	go func() {
+		// with no reconnects, listen returns once the scripted events run out
+		listen(options, nil, stateChanges, events)
+		close(events)
+	}()
+	var heard []libplumraw.Event
+	for pe := range events {
+		if pe.pad != "test" {
+			t.Errorf("event labeled with pad %q, want test", pe.pad)
+		}
+		heard = append(heard, pe.ev)
+	}
+	if want := makeTestLightpad(nil).Events; !reflect.DeepEqual(heard, want) {
+		t.Errorf("heard %+v, want the scripted %+v", heard, want)
+	}
+}
+
+func TestTestLightpadSubscribeCancel(t *testing.T) {
+	stateChanges := make(chan libplumraw.Event, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := makeTestLightpad(stateChanges).Subscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-stateChanges
+	cancel()
+	// cancelling ends the stream, closing the channel as a dropped connection would
+	for range stateChanges {
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// topologyCache is every house's tree as last fetched from the web API.
+type topologyCache struct {
+	Fetched time.Time   `json:"fetched"`
+	Houses  []houseTree `json:"houses"`
+}
+
+// topologyCachePath is where the topology is cached between runs.
+func topologyCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "plumcliraw", "topology.json"), nil
+}
+
+// readTopologyCache returns the cached topology, or nil if there isn't one.
+func readTopologyCache() (*topologyCache, error) {
+	path, err := topologyCachePath()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache := &topologyCache{}
+	if err := json.Unmarshal(buf, cache); err != nil {
+		return nil, fmt.Errorf("failed to parse topology cache %s: %s", path, err)
+	}
+	return cache, nil
+}
+
+// writeTopologyCache saves houses as the cached topology. The houses include
+// their HATs, so the file is kept readable by its owner alone.
+func writeTopologyCache(houses []houseTree) error {
+	path, err := topologyCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(topologyCache{Fetched: time.Now(), Houses: houses})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0600)
+}
+
+// fetchHouseTrees fetches the whole tree of each house in hids concurrently.
+func fetchHouseTrees(conn libplumraw.WebConnection, hids []string) ([]houseTree, error) {
+	w := &treeWalker{conn: conn, sem: make(chan struct{}, treeFetchers)}
+	trees := make([]houseTree, len(hids))
+	err := w.each(len(hids), func(i int) (err error) {
+		trees[i], err = w.house(hids[i])
+		return err
+	})
+	return trees, err
+}
+
+// cachedHouseTrees returns the cached tree of every house if the cache is
+// younger than --cache-ttl, and nil if it isn't or mustn't be used: with
+// --refresh, with --watch, which should show every redraw afresh, or in --test.
+func cachedHouseTrees(options Options) []houseTree {
+	if options.Refresh || options.Watch || options.CacheTTL <= 0 || options.TestMode {
+		return nil
+	}
+	cache, err := readTopologyCache()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring the topology cache: %s\n", err)
+		return nil
+	}
+	if cache == nil || time.Since(cache.Fetched) >= options.CacheTTL {
+		return nil
+	}
+	return cache.Houses
+}
+
+// houseTrees returns the tree of every house, from the topology cache if
+// cachedHouseTrees can serve it, and otherwise fetched from the web API and
+// cached.
+func houseTrees(conn libplumraw.WebConnection, options Options) ([]houseTree, error) {
+	if trees := cachedHouseTrees(options); trees != nil {
+		return trees, nil
+	}
+	hids, err := conn.GetHouses()
+	if err != nil {
+		return nil, err
+	}
+	trees, err := fetchHouseTrees(conn, hids)
+	if err != nil {
+		return nil, err
+	}
+	if options.CacheTTL > 0 && !options.TestMode {
+		if err := writeTopologyCache(trees); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to cache the topology: %s\n", err)
+		}
+	}
+	return trees, nil
+}
+
+// runCacheStatus describes what is cached and how old it is.
+func runCacheStatus(options Options) {
+	path, err := topologyCachePath()
+	checkError(err)
+	cache, err := readTopologyCache()
+	checkError(err)
+	hats, err := readHATCache()
+	checkError(err)
+
+	status := struct {
+		Path      string     `json:"path"`
+		Fetched   *time.Time `json:"fetched,omitempty"`
+		Age       string     `json:"age,omitempty"`
+		Fresh     bool       `json:"fresh"`
+		Houses    int        `json:"houses"`
+		Rooms     int        `json:"rooms"`
+		Loads     int        `json:"loads"`
+		Lightpads int        `json:"lightpads"`
+		HATs      int        `json:"hats"`
+	}{Path: path, HATs: len(hats)}
+	if cache != nil {
+		age := time.Since(cache.Fetched)
+		status.Fetched = &cache.Fetched
+		status.Age = age.Round(time.Second).String()
+		status.Fresh = age < options.CacheTTL
+		status.Houses = len(cache.Houses)
+		for _, house := range cache.Houses {
+			status.Rooms += len(house.Rooms)
+			for _, room := range house.Rooms {
+				status.Loads += len(room.Loads)
+				for _, load := range room.Loads {
+					status.Lightpads += len(load.Lightpads)
+				}
+			}
+		}
+	}
+	if jsonOutput() {
+		printResult(status)
+		return
+	}
+	fmt.Printf("Topology cache: %s\n", status.Path)
+	if cache == nil {
+		fmt.Println("  empty")
+	} else {
+		state := "stale"
+		if status.Fresh {
+			state = "fresh"
+		}
+		fmt.Printf("  fetched %s, %s ago (%s with --cache-ttl %s)\n", formatTime(cache.Fetched), status.Age, state, options.CacheTTL)
+		fmt.Printf("  %d houses, %d rooms, %d loads, %d Lightpads\n", status.Houses, status.Rooms, status.Loads, status.Lightpads)
+		for _, house := range cache.Houses {
+			fmt.Printf("  House %s (%s)\n", house.Name, house.ID)
+			for _, room := range house.Rooms {
+				fmt.Printf("    Room %s (%s)\n", room.Name, room.ID)
+				for _, load := range room.Loads {
+					fmt.Printf("      Load %s (%s), %d Lightpads\n", load.Name, load.ID, len(load.Lightpads))
+				}
+			}
+		}
+	}
+	fmt.Printf("HAT cache: %d houses\n", status.HATs)
+}
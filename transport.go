@@ -0,0 +1,263 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// padHTTPClient returns the client used to talk to Lightpads. Lightpads serve
+// a self-signed certificate so verification is skipped. With --dry-run
+// requests are printed rather than sent.
+func padHTTPClient(options Options) *http.Client {
+	minVersion, err := parseTLSVersion(options.PadTLSMinVersion)
+	if err != nil {
+		fmt.Printf("Error: --pad-tls-min-version: %s\n", err)
+		exit(1)
+	}
+	var base http.RoundTripper = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			MinVersion:         minVersion,
+		},
+	}
+	if options.DryRun {
+		base = &dryRunTransport{}
+	}
+	return &http.Client{Transport: wrapTransport(base, options)}
+}
+
+// parseTLSVersion turns a version like "1.2" into its crypto/tls constant. An
+// empty version returns 0, which leaves the choice to crypto/tls. TLS 1.0 and
+// 1.1 are refused; there's no reason to insist on a version that weak.
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.0", "1.1":
+		return 0, fmt.Errorf("TLS %s is insecure; use 1.2 or 1.3", version)
+	}
+	return 0, fmt.Errorf("unknown TLS version %q; use 1.2 or 1.3", version)
+}
+
+// wrapTransport layers the CLI's request handling on top of base.
+func wrapTransport(base http.RoundTripper, options Options) http.RoundTripper {
+	statuses, err := parseStatusList(options.RetryOnStatus)
+	if err != nil {
+		fmt.Printf("Error: --retry-on-status: %s\n", err)
+		exit(1)
+	}
+	if options.Stats {
+		if commandStats == nil {
+			commandStats = &requestStats{}
+		}
+		base = &statsTransport{base: base, stats: commandStats}
+	}
+	if options.SlowThreshold > 0 {
+		base = &slowTransport{base: base, threshold: options.SlowThreshold}
+	}
+	retries := options.Retries
+	if !options.RetryNonIdempotent && !isIdempotent(options.Action) {
+		retries = 0
+	}
+	retrier := &retryTransport{
+		base:      base,
+		retries:   retries,
+		statuses:  statuses,
+		baseDelay: options.BackoffBase,
+		maxDelay:  options.BackoffCap,
+		budget:    sharedRetryBudget(options.RetryBudget),
+	}
+	// outside the retries so every attempt carries the same ID
+	return &requestIDTransport{base: retrier, id: options.RequestID}
+}
+
+// parseStatusList turns a comma separated list like "429,503" into a set of
+// status codes.
+func parseStatusList(list string) (map[int]bool, error) {
+	statuses := make(map[int]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("%q is not an HTTP status code", field)
+		}
+		statuses[code] = true
+	}
+	return statuses, nil
+}
+
+// retryTransport retries requests that fail with a network error or that
+// come back with one of the configured status codes, backing off
+// exponentially (with jitter) between attempts.
+type retryTransport struct {
+	base      http.RoundTripper
+	retries   int
+	statuses  map[int]bool
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	budget    *retryBudget
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a body we can't rewind can only be sent once
+	replayable := req.Body == nil || req.GetBody != nil
+	for attempt := 0; ; attempt++ {
+		try := req
+		var start time.Time
+		if attempt > 0 {
+			start = time.Now()
+			if req.Body != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				try = req.Clone(req.Context())
+				try.Body = body
+			}
+			time.Sleep(t.backoff(attempt))
+		}
+		resp, err := t.base.RoundTrip(try)
+		if attempt > 0 {
+			t.budget.spend(time.Since(start))
+		}
+		if !replayable || attempt >= t.retries || !t.retryable(resp, err) || t.budget.exhausted() {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+}
+
+func (t *retryTransport) retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return t.statuses[resp.StatusCode]
+}
+
+// backoff returns how long to wait before the given retry attempt (starting
+// at 1). The delay starts at baseDelay and doubles each attempt up to
+// maxDelay, so with the defaults of 250ms and 5s the sequence is 250ms,
+// 500ms, 1s, 2s, 4s, 5s, 5s... A random part of up to half of each delay is
+// then jittered away so concurrent clients don't retry in lockstep.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	return backoffDelay(attempt, t.baseDelay, t.maxDelay)
+}
+
+// backoffDelay is the jittered delay before the given attempt, doubling from
+// base up to max.
+func backoffDelay(attempt int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	delay := base << uint(attempt-1)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// slowTransport warns on stderr about any request that takes longer than
+// threshold to come back.
+type slowTransport struct {
+	base      http.RoundTripper
+	threshold time.Duration
+}
+
+func (t *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if took := time.Since(start); took > t.threshold {
+		fmt.Fprintf(os.Stderr, "Warning: %s %s took %s\n", req.Method, req.URL, took)
+	}
+	return resp, err
+}
+
+// commandRetryBudget is shared by every retryTransport so that --retry-budget
+// applies to the command as a whole.
+var commandRetryBudget *retryBudget
+
+func sharedRetryBudget(total time.Duration) *retryBudget {
+	if total > 0 && commandRetryBudget == nil {
+		commandRetryBudget = &retryBudget{remaining: total}
+	}
+	return commandRetryBudget
+}
+
+// retryBudget caps the total time spent retrying. Once it is used up, failed
+// requests fail straight away instead of being retried. A nil budget is
+// unlimited.
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining time.Duration
+}
+
+func (b *retryBudget) spend(d time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining -= d
+}
+
+func (b *retryBudget) exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining <= 0
+}
+
+// requestIDHeader carries an ID for the request so it can be matched up with
+// server or Lightpad side logs.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDTransport tags each request with id, or with a freshly generated
+// UUID if id is empty. The ID is logged under --verbose.
+type requestIDTransport struct {
+	base http.RoundTripper
+	id   string
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := t.id
+	if id == "" {
+		id = newUUID()
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(requestIDHeader, id)
+	if verbose {
+		fmt.Fprintf(os.Stderr, "request %s: %s %s\n", id, req.Method, req.URL)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	crand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedTransport answers each request with the next status in its script
+// and remembers the bodies it was sent.
+type scriptedTransport struct {
+	statuses []int
+	bodies   []string
+}
+
+func (s *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		buf, _ := ioutil.ReadAll(req.Body)
+		s.bodies = append(s.bodies, string(buf))
+	}
+	status := s.statuses[0]
+	if len(s.statuses) > 1 {
+		s.statuses = s.statuses[1:]
+	}
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestParseStatusList(t *testing.T) {
+	got, err := parseStatusList(" 429,503 ,,500")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || !got[429] || !got[500] || !got[503] {
+		t.Errorf("parseStatusList = %v, want 429, 500 and 503", got)
+	}
+	for _, bad := range []string{"429,abc", "99", "600", "5xx"} {
+		if _, err := parseStatusList(bad); err == nil {
+			t.Errorf("parseStatusList(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestRetryOnStatus(t *testing.T) {
+	statuses := map[int]bool{429: true, 503: true}
+	for _, tt := range []struct {
+		script []int
+		want   int
+		tries  int
+	}{
+		{[]int{503, 429, 200}, 200, 3},
+		// not in --retry-on-status, so returned as is
+		{[]int{500, 200}, 500, 1},
+		// out of retries
+		{[]int{503, 503, 503, 503}, 503, 3},
+	} {
+		base := &scriptedTransport{statuses: tt.script}
+		rt := &retryTransport{base: base, retries: 2, statuses: statuses}
+		req, _ := http.NewRequest("PUT", "https://pad/v2/setLogicalLoadLevel", strings.NewReader(`{"level":10}`))
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.want || len(base.bodies) != tt.tries {
+			t.Errorf("script %v: got %d after %d tries, want %d after %d", tt.script, resp.StatusCode, len(base.bodies), tt.want, tt.tries)
+		}
+		for _, body := range base.bodies {
+			if body != `{"level":10}` {
+				t.Errorf("script %v: retry sent body %q", tt.script, body)
+			}
+		}
+	}
+}
+
+func TestRetryUnreplayableBody(t *testing.T) {
+	base := &scriptedTransport{statuses: []int{503, 200}}
+	rt := &retryTransport{base: base, retries: 2, statuses: map[int]bool{503: true}}
+	req, _ := http.NewRequest("PUT", "https://pad/v2/setLogicalLoadLevel", nil)
+	req.Body = ioutil.NopCloser(io.MultiReader(strings.NewReader(`{"level":10}`)))
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the body was used up by the first try, so there must be no second
+	if resp.StatusCode != 503 || len(base.bodies) != 1 {
+		t.Errorf("got %d after %d tries, want 503 after 1", resp.StatusCode, len(base.bodies))
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	base, max := 250*time.Millisecond, 5*time.Second
+	// the documented sequence, before jitter takes up to half of each delay
+	sequence := []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, full := range sequence {
+		attempt := i + 1
+		for try := 0; try < 50; try++ {
+			if d := backoffDelay(attempt, base, max); d < full/2 || d > full {
+				t.Fatalf("backoffDelay(%d) = %s, want from %s to %s", attempt, d, full/2, full)
+			}
+		}
+	}
+	// shifting this far overflows, which must still give the cap
+	if d := backoffDelay(80, base, max); d < max/2 || d > max {
+		t.Errorf("backoffDelay(80) = %s, want from %s to %s", d, max/2, max)
+	}
+	if d := backoffDelay(3, 0, max); d != 0 {
+		t.Errorf("backoffDelay with no base = %s, want 0", d)
+	}
+}
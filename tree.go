@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/maplebed/libplumraw"
+)
+
+// treeFetchers caps how many requests GetHouseTree has in flight at once.
+const treeFetchers = 8
+
+// houseTree is a House with its rooms, their loads and the loads' Lightpads
+// nested inside it. SceneIDs and Scenes are only filled in for
+// --include-scenes, and are never cached.
+type houseTree struct {
+	libplumraw.House
+	Rooms    []roomTree         `json:"rooms"`
+	SceneIDs libplumraw.Scenes  `json:"sids,omitempty"`
+	Scenes   []libplumraw.Scene `json:"scenes,omitempty"`
+}
+
+type roomTree struct {
+	libplumraw.Room
+	Loads []loadTree `json:"loads"`
+}
+
+type loadTree struct {
+	libplumraw.LogicalLoad
+	Lightpads []libplumraw.LightpadSpec `json:"lightpads"`
+}
+
+// treeWalker fetches the pieces of a house tree concurrently, with no more
+// than treeFetchers requests running at a time.
+type treeWalker struct {
+	conn libplumraw.WebConnection
+	sem  chan struct{}
+}
+
+// each calls fetch for 0 through n-1 concurrently and returns the first
+// error any of them hit.
+func (w *treeWalker) each(n int, fetch func(i int) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fetch(i)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch runs f while holding one of the walker's request slots.
+func (w *treeWalker) fetch(f func() error) error {
+	w.sem <- struct{}{}
+	defer func() { <-w.sem }()
+	return f()
+}
+
+func (w *treeWalker) house(hid string) (houseTree, error) {
+	var tree houseTree
+	err := w.fetch(func() (err error) {
+		tree.House, err = w.conn.GetHouse(hid)
+		return err
+	})
+	if err != nil {
+		return tree, err
+	}
+	tree.Rooms = make([]roomTree, len(tree.RoomIDs))
+	err = w.each(len(tree.RoomIDs), func(i int) (err error) {
+		tree.Rooms[i], err = w.room(tree.RoomIDs[i])
+		return err
+	})
+	return tree, err
+}
+
+func (w *treeWalker) room(rid string) (roomTree, error) {
+	var tree roomTree
+	err := w.fetch(func() (err error) {
+		tree.Room, err = w.conn.GetRoom(rid)
+		return err
+	})
+	if err != nil {
+		return tree, err
+	}
+	tree.Loads = make([]loadTree, len(tree.LLIDs))
+	err = w.each(len(tree.LLIDs), func(i int) (err error) {
+		tree.Loads[i], err = w.load(tree.LLIDs[i])
+		return err
+	})
+	return tree, err
+}
+
+func (w *treeWalker) load(llid string) (loadTree, error) {
+	var tree loadTree
+	err := w.fetch(func() (err error) {
+		tree.LogicalLoad, err = w.conn.GetLogicalLoad(llid)
+		return err
+	})
+	if err != nil {
+		return tree, err
+	}
+	tree.Lightpads = make([]libplumraw.LightpadSpec, len(tree.LPIDs))
+	err = w.each(len(tree.LPIDs), func(i int) error {
+		return w.fetch(func() (err error) {
+			tree.Lightpads[i], err = w.conn.GetLightpad(tree.LPIDs[i])
+			return err
+		})
+	})
+	return tree, err
+}
+
+// scenes adds the house's scene IDs to tree, and with resolve the description
+// of each scene as well.
+func (w *treeWalker) scenes(tree *houseTree, resolve bool) error {
+	err := w.fetch(func() (err error) {
+		tree.SceneIDs, err = w.conn.GetScenes(tree.ID)
+		return err
+	})
+	if err != nil || !resolve {
+		return err
+	}
+	tree.Scenes = make([]libplumraw.Scene, len(tree.SceneIDs))
+	return w.each(len(tree.SceneIDs), func(i int) error {
+		return w.fetch(func() (err error) {
+			tree.Scenes[i], err = w.conn.GetScene(tree.SceneIDs[i])
+			return err
+		})
+	})
+}
+
+// runGetHouseTree prints the whole topology of the house in --id, or of every
+// house if no ID is given, as one JSON document. With --tree-file the
+// document is written there instead. --include-scenes adds each house's
+// scenes, and --resolve their descriptions.
+func runGetHouseTree(conn libplumraw.WebConnection, options Options) {
+	var trees []houseTree
+	var err error
+	if options.ID == "" {
+		trees, err = houseTrees(conn, options)
+		checkError(err)
+	} else {
+		for _, tree := range cachedHouseTrees(options) {
+			if tree.ID == options.ID {
+				trees = append(trees, tree)
+			}
+		}
+		if len(trees) == 0 {
+			// not cached, or not one of ours; fetch just this house
+			trees, err = fetchHouseTrees(conn, []string{options.ID})
+			checkError(err)
+		}
+	}
+	if options.IncludeScenes {
+		w := &treeWalker{conn: conn, sem: make(chan struct{}, treeFetchers)}
+		err = w.each(len(trees), func(i int) error {
+			return w.scenes(&trees[i], options.Resolve)
+		})
+		checkError(err)
+	}
+	buf, err := json.MarshalIndent(trees, "", "  ")
+	checkError(err)
+	if options.TreeFile != "" {
+		err = ioutil.WriteFile(options.TreeFile, append(buf, '\n'), 0600)
+		checkError(err)
+		return
+	}
+	fmt.Println(string(buf))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+const libplumrawPath = "github.com/maplebed/libplumraw"
+
+// libplumrawVersion returns the version of libplumraw this binary was built
+// with, as recorded in the build info.
+func libplumrawVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != libplumrawPath {
+			continue
+		}
+		if dep.Replace != nil {
+			return fmt.Sprintf("%s (replaced by %s %s)", dep.Version, dep.Replace.Path, dep.Replace.Version)
+		}
+		return dep.Version
+	}
+	return "unknown"
+}
+
+func printVersion() {
+	fmt.Printf("plumcliraw %s\n", version)
+	fmt.Printf("libplumraw %s\n", libplumrawVersion())
+	fmt.Println("All parsing of Plum web API and Lightpad responses is done by libplumraw;")
+	fmt.Println("if responses start failing to parse, check for a newer libplumraw first.")
+}
+
+// isParseError reports whether err came from decoding a response from the
+// web API or a Lightpad, rather than from our own --conf input.
+func isParseError(err error) bool {
+	var confErr *confError
+	if errors.As(err, &confErr) {
+		return false
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// confError wraps failures to decode --conf so they aren't mistaken for a
+// response libplumraw couldn't parse.
+type confError struct {
+	err error
+}
+
+func (c *confError) Error() string { return c.err.Error() }
+func (c *confError) Unwrap() error { return c.err }